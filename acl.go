@@ -0,0 +1,126 @@
+package socks5
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// ACLRule allows or denies the requests matching all conditions
+type ACLRule struct {
+	Allow      bool
+	Code       ReplyCode // sent on deny, ReplyConnectionNotAllowed if zero
+	Conditions []Condition
+}
+
+// ACL allows or denies requests before any dial.
+// The first matching rule wins, requests matching no rule are allowed.
+//
+// CIDR conditions only match IP destinations,
+// domain names are not resolved by the ACL.
+type ACL struct {
+	Rules []*ACLRule
+}
+
+// DefaultDenyRules blocks SMTP and the link-local metadata addresses
+var DefaultDenyRules = []string{
+	"port:25",
+	"port:465",
+	"port:587",
+	"cidr:169.254.0.0/16",
+	"cidr:fe80::/10",
+	"cidr:fd00:ec2::254",
+	"host:metadata.google.internal",
+}
+
+// NewACL creates an ACL with DefaultDenyRules
+func NewACL() *ACL {
+	a := &ACL{}
+	for _, r := range DefaultDenyRules {
+		c, err := ParseCondition(r)
+		if err != nil {
+			panic(err)
+		}
+		a.Rules = append(a.Rules, &ACLRule{Conditions: []Condition{c}})
+	}
+	return a
+}
+
+// LoadACL reads the rules from a file, see ParseACL
+func LoadACL(filename string) (*ACL, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseACL(f)
+}
+
+// ParseACL reads the rules, one per line.
+//
+//	# allow|deny[:code] <condition>...
+//	allow src:10.0.0.0/8 user:admin
+//	deny:0x04 suffix:internal.example.com
+//	deny port:22
+func ParseACL(r io.Reader) (*ACL, error) {
+	a := &ACL{}
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		rule, err := parseACLRule(strings.Fields(line))
+		if err != nil {
+			return nil, fmt.Errorf("line %d : %w", n, err)
+		}
+		a.Rules = append(a.Rules, rule)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func parseACLRule(fields []string) (*ACLRule, error) {
+	rule := &ACLRule{}
+	switch action := fields[0]; {
+	case action == "allow":
+		rule.Allow = true
+	case action == "deny":
+	case strings.HasPrefix(action, "deny:"):
+		code, err := parseReplyCode(action[len("deny:"):])
+		if err != nil {
+			return nil, err
+		}
+		rule.Code = code
+	default:
+		return nil, fmt.Errorf("unknown action : %s", action)
+	}
+	conds, err := parseConditions(fields[1:])
+	if err != nil {
+		return nil, err
+	}
+	rule.Conditions = conds
+	return rule, nil
+}
+
+// Permit can be used as Server.Permit
+func (a *ACL) Permit(ctx context.Context, auth *Authentication, req *Request) ReplyCode {
+	for _, r := range a.Rules {
+		if !matchAll(ctx, r.Conditions, auth, req) {
+			continue
+		}
+		if r.Allow {
+			return ReplySucceed
+		}
+		if r.Code == ReplySucceed {
+			return ReplyConnectionNotAllowed
+		}
+		return r.Code
+	}
+	return ReplySucceed
+}
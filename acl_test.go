@@ -0,0 +1,97 @@
+package socks5
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestACL(t *testing.T) {
+	rules := `
+allow src:10.0.0.0/8 user:admin
+deny:0x04 suffix:internal.example.com
+deny src:10.0.0.0/8 port:22
+`
+	a, err := ParseACL(strings.NewReader(rules))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.Rules = append(a.Rules, NewACL().Rules...)
+
+	remote := &net.TCPAddr{IP: net.ParseIP("10.1.1.1"), Port: 5000}
+	ctx := withConnInfo(context.Background(), &net.TCPConn{})
+	getConnInfo(ctx).remote = remote
+	admin, _ := newAuth("admin", "")
+	user, _ := newAuth("user", "")
+
+	t1 := []struct {
+		auth *Authentication
+		addr string
+		code ReplyCode
+	}{
+		{admin, "a.internal.example.com:80", ReplySucceed},
+		{user, "a.internal.example.com:80", ReplyHostUnreachable},
+		{user, "192.0.2.1:22", ReplyConnectionNotAllowed},
+		{admin, "192.0.2.1:22", ReplySucceed},
+		{user, "mail.example.com:25", ReplyConnectionNotAllowed},
+		{nil, "169.254.169.254:80", ReplyConnectionNotAllowed},
+		{nil, "metadata.google.internal:80", ReplyConnectionNotAllowed},
+		{nil, "example.com:443", ReplySucceed},
+	}
+	for _, i := range t1 {
+		req, err := newRequest("tcp", i.addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code := a.Permit(ctx, i.auth, req); code != i.code {
+			t.Fatalf("%s : %s != %s", i.addr, code, i.code)
+		}
+	}
+
+	// IP literals sent as domain names
+	for _, host := range []string{"169.254.169.254", "169.254.169.254.", "::ffff:169.254.169.254"} {
+		req := &Request{Cmd: CmdConnect, Dst: Address{Type: AddrTypeDN, Domain: host, Port: 80}}
+		if code := a.Permit(ctx, nil, req); code != ReplyConnectionNotAllowed {
+			t.Fatalf("%s : %s", host, code)
+		}
+	}
+
+	if _, err := ParseACL(strings.NewReader("deny:0 port:1")); err == nil {
+		t.Fatal("Error")
+	}
+	if _, err := ParseACL(strings.NewReader("drop port:1")); err == nil {
+		t.Fatal("Error")
+	}
+}
+
+func TestServerPermit(t *testing.T) {
+	l1, err := net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l1.Close()
+
+	a, err := ParseACL(strings.NewReader("deny src:127.0.0.0/8"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer()
+	s.Permit = a.Permit
+	l2, err := net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l2.Close()
+	go s.Serve(l2)
+
+	c, err := NewClient(l2.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.Dial("tcp", l1.Addr().String())
+	if !errors.Is(err, ErrReplyFailure) {
+		t.Fatal(err)
+	}
+}
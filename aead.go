@@ -0,0 +1,288 @@
+package socks5
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+)
+
+// ErrFrameAuth represents a sealed frame fails the authentication
+var ErrFrameAuth = errors.New("frame authentication failed")
+
+// ErrWeakPSK represents a pre-shared key shorter than MinPSKLen
+var ErrWeakPSK = errors.New("weak pre-shared key")
+
+// MinPSKLen is the minimum length of the pre-shared keys of MethodAEAD
+const MinPSKLen = 32
+
+// MethodAEAD encapsulates the rest of the connection in AES-256-GCM frames
+// after an ephemeral P-256 key exchange authenticated by a pre-shared key:
+//
+//	client: VER CPUB(65) CNONCE(32)
+//	server: VER SPUB(65) SNONCE(32)
+//	client: CMAC(32)
+//	server: SMAC(32)
+//	frames: LEN(2) SEALED(LEN)
+//
+// The server proves the key only to a client which proved it first,
+// so a client without the key learns nothing to guess it offline.
+// With T = CPUB CNONCE SPUB SNONCE and K = HMAC-SHA256(psk, ECDH),
+// SMAC = HMAC-SHA256(K, "server" T) and CMAC = HMAC-SHA256(K, "client" T).
+// The keys of the client and the server frames are HMAC-SHA256(K, "c2s" T)
+// and HMAC-SHA256(K, "s2c" T), the nonce of a frame is its counter.
+// The first frame of the server is VER METHOD, the method selected among
+// the other methods of the client, which runs in the frames.
+
+const (
+	aeadPubLen     = 65
+	aeadNonceLen   = 32
+	aeadMaxPayload = 16 * 1024
+)
+
+type aeadKeys struct {
+	transcript []byte
+	key        []byte
+}
+
+func newAEADKeys(psk, shared, transcript []byte) *aeadKeys {
+	return &aeadKeys{transcript: transcript, key: hmacSign(psk, shared, nil)}
+}
+
+func (k *aeadKeys) derive(label string) []byte {
+	return hmacSign(k.key, []byte(label), k.transcript)
+}
+
+func (k *aeadKeys) seal(conn io.ReadWriter, client bool) (io.ReadWriter, error) {
+	c2s, err := newGCM(k.derive("c2s"))
+	if err != nil {
+		return nil, err
+	}
+	s2c, err := newGCM(k.derive("s2c"))
+	if err != nil {
+		return nil, err
+	}
+	if client {
+		return &sealedConn{rw: conn, open: s2c, seal: c2s}, nil
+	}
+	return &sealedConn{rw: conn, open: c2s, seal: s2c}, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// ecdh returns an ephemeral key pair, the public key is uncompressed
+func ecdh() (priv, pub []byte, err error) {
+	priv, x, y, err := elliptic.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	return priv, elliptic.Marshal(elliptic.P256(), x, y), nil
+}
+
+func ecdhShared(priv, peer []byte) ([]byte, error) {
+	x, y := elliptic.Unmarshal(elliptic.P256(), peer)
+	if x == nil {
+		return nil, fmt.Errorf("%w : invalid public key", ErrFrameAuth)
+	}
+	sx, _ := elliptic.P256().ScalarMult(x, y, priv)
+	shared := make([]byte, 32)
+	b := sx.Bytes()
+	copy(shared[len(shared)-len(b):], b)
+	return shared, nil
+}
+
+func checkPSK(psk []byte) error {
+	if len(psk) < MinPSKLen {
+		return fmt.Errorf("%w : %d bytes", ErrWeakPSK, len(psk))
+	}
+	return nil
+}
+
+// acceptAEAD runs the key exchange of the server
+func acceptAEAD(conn io.ReadWriter, psk []byte) (io.ReadWriter, error) {
+	hello := make([]byte, 1+aeadPubLen+aeadNonceLen)
+	if _, err := io.ReadFull(conn, hello); err != nil {
+		return nil, err
+	}
+	if hello[0] != Version5 {
+		return nil, fmt.Errorf("%w : %02x", ErrInvalidVersion, hello[0])
+	}
+	priv, pub, err := ecdh()
+	if err != nil {
+		return nil, err
+	}
+	shared, err := ecdhShared(priv, hello[1:1+aeadPubLen])
+	if err != nil {
+		return nil, err
+	}
+	reply := make([]byte, 1+aeadPubLen+aeadNonceLen)
+	reply[0] = Version5
+	copy(reply[1:], pub)
+	if _, err := rand.Read(reply[1+aeadPubLen:]); err != nil {
+		return nil, err
+	}
+	if _, err := conn.Write(reply); err != nil {
+		return nil, err
+	}
+	keys := newAEADKeys(psk, shared, append(hello[1:], reply[1:]...))
+	mac := make([]byte, sha256.Size)
+	if _, err := io.ReadFull(conn, mac); err != nil {
+		return nil, err
+	}
+	if !hmac.Equal(mac, keys.derive("client")) {
+		return nil, fmt.Errorf("%w : pre-shared key mismatch", ErrAuthFailed)
+	}
+	if _, err := conn.Write(keys.derive("server")); err != nil {
+		return nil, err
+	}
+	return keys.seal(conn, false)
+}
+
+// connectAEAD runs the key exchange of the client
+func connectAEAD(conn io.ReadWriter, psk []byte) (io.ReadWriter, error) {
+	priv, pub, err := ecdh()
+	if err != nil {
+		return nil, err
+	}
+	hello := make([]byte, 1+aeadPubLen+aeadNonceLen)
+	hello[0] = Version5
+	copy(hello[1:], pub)
+	if _, err := rand.Read(hello[1+aeadPubLen:]); err != nil {
+		return nil, err
+	}
+	if _, err := conn.Write(hello); err != nil {
+		return nil, err
+	}
+	reply := make([]byte, 1+aeadPubLen+aeadNonceLen)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return nil, err
+	}
+	if reply[0] != Version5 {
+		return nil, fmt.Errorf("%w : %02x", ErrInvalidVersion, reply[0])
+	}
+	shared, err := ecdhShared(priv, reply[1:1+aeadPubLen])
+	if err != nil {
+		return nil, err
+	}
+	keys := newAEADKeys(psk, shared, append(hello[1:], reply[1:]...))
+	if _, err := conn.Write(keys.derive("client")); err != nil {
+		return nil, err
+	}
+	mac := make([]byte, sha256.Size)
+	if _, err := io.ReadFull(conn, mac); err != nil {
+		// the server closes on a wrong key
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			err = fmt.Errorf("%w : pre-shared key mismatch", ErrAuthFailed)
+		}
+		return nil, err
+	}
+	if !hmac.Equal(mac, keys.derive("server")) {
+		return nil, fmt.Errorf("%w : pre-shared key mismatch", ErrAuthFailed)
+	}
+	return keys.seal(conn, true)
+}
+
+// sealedConn reads and writes the frames of MethodAEAD
+type sealedConn struct {
+	rw   io.ReadWriter
+	open cipher.AEAD
+	seal cipher.AEAD
+
+	rmu    sync.Mutex
+	rnonce [12]byte
+	buf    []byte
+
+	wmu    sync.Mutex
+	wnonce [12]byte
+}
+
+func (c *sealedConn) Read(p []byte) (int, error) {
+	c.rmu.Lock()
+	defer c.rmu.Unlock()
+	for len(c.buf) == 0 {
+		hdr := []byte{0, 0}
+		if _, err := io.ReadFull(c.rw, hdr); err != nil {
+			return 0, err
+		}
+		frame := make([]byte, binary.BigEndian.Uint16(hdr))
+		if _, err := io.ReadFull(c.rw, frame); err != nil {
+			return 0, unexpectedEOF(err)
+		}
+		plain, err := c.open.Open(frame[:0], c.rnonce[:], frame, hdr)
+		if err != nil {
+			return 0, ErrFrameAuth
+		}
+		increment(c.rnonce[:])
+		c.buf = plain
+	}
+	n := copy(p, c.buf)
+	c.buf = c.buf[n:]
+	return n, nil
+}
+
+func (c *sealedConn) Write(p []byte) (int, error) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	written := 0
+	for len(p) > 0 {
+		n := len(p)
+		if n > aeadMaxPayload {
+			n = aeadMaxPayload
+		}
+		frame := make([]byte, 2, 2+n+c.seal.Overhead())
+		binary.BigEndian.PutUint16(frame, uint16(n+c.seal.Overhead()))
+		frame = c.seal.Seal(frame, c.wnonce[:], p[:n], frame[:2])
+		increment(c.wnonce[:])
+		if _, err := c.rw.Write(frame); err != nil {
+			return written, err
+		}
+		written += n
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// increment increments the big-endian counter
+func increment(b []byte) {
+	for i := len(b) - 1; i >= 0; i-- {
+		b[i]++
+		if b[i] != 0 {
+			return
+		}
+	}
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// encapConn is a client connection encapsulated by the method
+type encapConn struct {
+	net.Conn
+	rw io.ReadWriter
+}
+
+func (c *encapConn) Read(p []byte) (int, error) {
+	return c.rw.Read(p)
+}
+
+func (c *encapConn) Write(p []byte) (int, error) {
+	return c.rw.Write(p)
+}
@@ -0,0 +1,197 @@
+package socks5
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestAEAD(t *testing.T) {
+	echo := testEchoServer(t)
+	defer echo.Close()
+
+	psk := bytes.Repeat([]byte("k"), MinPSKLen)
+	s := NewServer()
+	s.PSK = psk
+	l, err := net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go s.Serve(l)
+
+	c, err := NewClient(l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.PSK = psk
+	conn, err := c.Dial("tcp", echo.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := conn.(*encapConn); !ok {
+		t.Fatal(conn)
+	}
+	msg := bytes.Repeat([]byte("sealed"), 10000)
+	go conn.Write(msg)
+	buf := make([]byte, len(msg))
+	if _, err := io.ReadFull(conn, buf); err != nil || !bytes.Equal(buf, msg) {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	c.PSK = bytes.Repeat([]byte("w"), MinPSKLen)
+	if _, err := c.Dial("tcp", echo.Addr().String()); !errors.Is(err, ErrAuthFailed) {
+		t.Fatal(err)
+	}
+
+	// the server proves the key after the client only
+	raw, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer raw.Close()
+	_, pub, err := ecdh()
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw.Write([]byte{Version5, 2, byte(MethodAEAD), byte(MethodNotRequired)})
+	raw.Write(append(append([]byte{Version5}, pub...), make([]byte, aeadNonceLen)...))
+	raw.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	buf = make([]byte, 2+1+aeadPubLen+aeadNonceLen+1)
+	if n, err := io.ReadAtLeast(raw, buf, len(buf)); n != len(buf)-1 {
+		t.Fatal(n, err)
+	}
+
+	// weak keys
+	c.PSK = []byte("short")
+	if _, err := c.Dial("tcp", echo.Addr().String()); !errors.Is(err, ErrWeakPSK) {
+		t.Fatal(err)
+	}
+	c1, s1 := net.Pipe()
+	defer c1.Close()
+	go func() {
+		defer s1.Close()
+		weak := &Server{PSK: []byte("short")}
+		if _, err := weak.Handshake(context.Background(), s1); !errors.Is(err, ErrWeakPSK) {
+			t.Error(err)
+		}
+	}()
+	c1.Write([]byte{Version5, 2, byte(MethodAEAD), byte(MethodNotRequired)})
+	if _, err := io.ReadFull(c1, buf[:2]); err != io.EOF {
+		t.Fatal(err)
+	}
+
+	// clients without the key
+	c.PSK = nil
+	conn, err = c.Dial("tcp", echo.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := conn.(*encapConn); ok {
+		t.Fatal(conn)
+	}
+	conn.Close()
+
+	// no fallback to the clear
+	s3 := NewServer()
+	l3, err := net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l3.Close()
+	go s3.Serve(l3)
+	c3, err := NewClient(l3.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c3.PSK = psk
+	if _, err := c3.Dial("tcp", echo.Addr().String()); !errors.Is(err, ErrMethodNoAcceptable) {
+		t.Fatal(err)
+	}
+
+	// username/password runs inside
+	s2 := NewServerWithAuth("user", "password")
+	s2.PSK = psk
+	l2, err := net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l2.Close()
+	go s2.Serve(l2)
+
+	c2, err := NewClientWithAuth(l2.Addr().String(), "nobody", "wrong")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c2.PSK = psk
+	if _, err := c2.Dial("tcp", echo.Addr().String()); !errors.Is(err, ErrAuthFailed) {
+		t.Fatal(err)
+	}
+	c2, err = NewClientWithAuth(l2.Addr().String(), "user", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c2.PSK = psk
+	conn, err = c2.Dial("tcp", echo.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := conn.(*encapConn); !ok {
+		t.Fatal(conn)
+	}
+	conn.Close()
+}
+
+func testEchoServer(t *testing.T) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return l
+}
+
+func TestSealedConn(t *testing.T) {
+	keys := newAEADKeys([]byte("psk"), []byte("shared"), []byte("transcript"))
+	var wire bytes.Buffer
+	client, err := keys.seal(&wire, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := keys.seal(&wire, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, msg := range []string{"request", "reply"} {
+		if _, err := client.Write([]byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, 64)
+		if n, err := server.Read(buf); err != nil || string(buf[:n]) != msg {
+			t.Fatal(string(buf[:n]), err)
+		}
+	}
+
+	// tampered frame
+	client.Write([]byte("data"))
+	wire.Bytes()[3] ^= 1
+	if _, err := server.Read(make([]byte, 64)); !errors.Is(err, ErrFrameAuth) {
+		t.Fatal(err)
+	}
+}
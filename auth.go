@@ -29,8 +29,17 @@ type Authentication struct {
 	Ver      byte
 	Username []byte
 	Password []byte
+
+	// Attributes of the identity, set by Server.Authenticate
+	Attributes map[string][]string
+
+	// Params are stripped from the username by Server.UsernameParams
+	Params map[string]string
 }
 
+// AttributeGroup is the attribute of the groups of a user
+const AttributeGroup = "group"
+
 func newAuth(username, password string) (*Authentication, error) {
 	a := &Authentication{
 		Ver:      Version5,
@@ -107,7 +116,11 @@ func readAuthStatus(r io.Reader) (err error) {
 	if buf[0] != Version5 {
 		return fmt.Errorf("%w : %02x", ErrInvalidVersion, buf[0])
 	}
-	if AuthStatus(buf[1]) != AuthSuccess {
+	switch AuthStatus(buf[1]) {
+	case AuthSuccess:
+	case AuthInvalidParams:
+		return ErrInvalidParams
+	default:
 		return ErrAuthFailed
 	}
 	return nil
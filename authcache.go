@@ -0,0 +1,85 @@
+package socks5
+
+import (
+	"crypto/sha256"
+	"sync"
+	"time"
+)
+
+// authCache caches the results of authentication backends,
+// keyed by a hash of the credentials so no password is kept.
+type authCache struct {
+	mu      sync.Mutex
+	entries map[[sha256.Size]byte]authCacheEntry
+}
+
+type authCacheEntry struct {
+	attrs   map[string][]string
+	ok      bool
+	expires time.Time
+}
+
+func authCacheKey(username, password string) [sha256.Size]byte {
+	return sha256.Sum256([]byte(username + "\x00" + password))
+}
+
+// get returns the result if it's cached and not expired
+func (c *authCache) get(username, password string) (attrs map[string][]string, ok, found bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := authCacheKey(username, password)
+	e, found := c.entries[key]
+	if !found {
+		return nil, false, false
+	}
+	if time.Now().After(e.expires) {
+		delete(c.entries, key)
+		return nil, false, false
+	}
+	return copyAttributes(e.attrs), e.ok, true
+}
+
+// put caches the result for ttl, zero ttl caches nothing
+func (c *authCache) put(username, password string, attrs map[string][]string, ok bool, ttl time.Duration) {
+	if ttl <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if c.entries == nil {
+		c.entries = make(map[[sha256.Size]byte]authCacheEntry)
+	}
+	for k, e := range c.entries {
+		if now.After(e.expires) {
+			delete(c.entries, k)
+		}
+	}
+	c.entries[authCacheKey(username, password)] = authCacheEntry{
+		attrs:   copyAttributes(attrs),
+		ok:      ok,
+		expires: now.Add(ttl),
+	}
+}
+
+func copyAttributes(attrs map[string][]string) map[string][]string {
+	if attrs == nil {
+		return nil
+	}
+	c := make(map[string][]string, len(attrs))
+	for k, v := range attrs {
+		c[k] = append([]string(nil), v...)
+	}
+	return c
+}
+
+// mergeAttributes adds the values of b to a
+func mergeAttributes(a, b map[string][]string) map[string][]string {
+	if a == nil {
+		a = make(map[string][]string, len(b))
+	}
+	for k, v := range b {
+		a[k] = append(a[k], v...)
+	}
+	return a
+}
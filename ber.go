@@ -0,0 +1,133 @@
+package socks5
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ErrInvalidBER represents a malformed BER element
+var ErrInvalidBER = errors.New("invalid BER")
+
+// BER tags
+const (
+	berBoolean     = 0x01
+	berInteger     = 0x02
+	berOctetString = 0x04
+	berEnumerated  = 0x0a
+	berSequence    = 0x30
+	berSet         = 0x31
+)
+
+// berElement is a decoded BER element, children are parsed on demand
+type berElement struct {
+	tag  byte
+	data []byte
+}
+
+// ber encodes an element of the contents
+func ber(tag byte, contents ...[]byte) []byte {
+	n := 0
+	for _, c := range contents {
+		n += len(c)
+	}
+	b := []byte{tag}
+	switch {
+	case n < 0x80:
+		b = append(b, byte(n))
+	case n < 0x100:
+		b = append(b, 0x81, byte(n))
+	case n < 0x10000:
+		b = append(b, 0x82, byte(n>>8), byte(n))
+	default:
+		b = append(b, 0x84, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	}
+	for _, c := range contents {
+		b = append(b, c...)
+	}
+	return b
+}
+
+func berString(tag byte, s string) []byte {
+	return ber(tag, []byte(s))
+}
+
+func berInt(tag byte, v int) []byte {
+	var b []byte
+	for {
+		b = append([]byte{byte(v)}, b...)
+		v >>= 8
+		if v == 0 && b[0] < 0x80 || v == -1 && b[0] >= 0x80 {
+			break
+		}
+	}
+	return ber(tag, b)
+}
+
+func berBool(v bool) []byte {
+	if v {
+		return ber(berBoolean, []byte{0xff})
+	}
+	return ber(berBoolean, []byte{0})
+}
+
+// readBER reads an element from the stream
+func readBER(r io.Reader) (berElement, error) {
+	var hdr [2]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return berElement{}, err
+	}
+	n := int(hdr[1])
+	if n&0x80 != 0 {
+		size := n & 0x7f
+		if size == 0 || size > 4 {
+			return berElement{}, fmt.Errorf("%w : length of %d bytes", ErrInvalidBER, size)
+		}
+		b := make([]byte, size)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return berElement{}, err
+		}
+		n = 0
+		for _, c := range b {
+			n = n<<8 | int(c)
+		}
+		if n < 0 || n > 1<<24 {
+			return berElement{}, fmt.Errorf("%w : length %d", ErrInvalidBER, n)
+		}
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return berElement{}, err
+	}
+	return berElement{tag: hdr[0], data: data}, nil
+}
+
+// children parses the contents of a constructed element
+func (e berElement) children() ([]berElement, error) {
+	var list []berElement
+	r := strings.NewReader(string(e.data))
+	for r.Len() > 0 {
+		c, err := readBER(r)
+		if err != nil {
+			return nil, fmt.Errorf("%w : %v", ErrInvalidBER, err)
+		}
+		list = append(list, c)
+	}
+	return list, nil
+}
+
+func (e berElement) int() int {
+	v := 0
+	for i, c := range e.data {
+		if i == 0 && c >= 0x80 {
+			v = -1
+		}
+		v = v<<8 | int(c)
+	}
+	return v
+}
+
+func (e berElement) str() string {
+	return string(e.data)
+}
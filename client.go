@@ -1,13 +1,36 @@
 package socks5
 
 import (
+	"context"
+	"crypto/tls"
 	"fmt"
 	"io"
 	"net"
+	"time"
 )
 
 // Client holds configure and options
 type Client struct {
+	// TLS connects to the proxy over TLS, nil is cleartext.
+	// RootCAs verifies the proxy and Certificates are the client certificates.
+	TLS *tls.Config
+
+	// PinnedSPKI are the accepted SPKIHash of the proxy certificate,
+	// empty accepts any verified certificate
+	PinnedSPKI [][]byte
+
+	// Secret of the username for MethodHMAC, which is offered
+	// before username/password if not nil
+	Secret []byte
+
+	// PSK is the pre-shared key of MethodAEAD, which is required
+	// if not nil, the other methods are offered to run inside it.
+	// It must be a random key of MinPSKLen bytes at least.
+	PSK []byte
+
+	// Compress offers MethodDeflate before the other methods
+	Compress bool
+
 	methods []Method
 	auth    *Authentication
 	proxy   string
@@ -37,29 +60,106 @@ func NewClientWithAuth(proxy, username, password string) (*Client, error) {
 }
 
 // Dial connects to the provided address via SOCKS5 proxy
-func (c *Client) Dial(network, address string) (conn net.Conn, err error) {
+func (c *Client) Dial(network, address string) (net.Conn, error) {
+	return c.DialContext(context.Background(), network, address)
+}
+
+// DialContext connects to the provided address via SOCKS5 proxy,
+// ctx bounds the connection to the proxy and the handshake
+func (c *Client) DialContext(ctx context.Context, network, address string) (conn net.Conn, err error) {
 	var req *Request
 	req, err = newRequest(network, address)
 	if err != nil {
 		return
 	}
-	conn, err = net.Dial("tcp", c.proxy)
+	if c.PSK != nil {
+		if err = checkPSK(c.PSK); err != nil {
+			return
+		}
+	}
+	var d net.Dialer
+	if path, ok := unixPath(c.proxy); ok {
+		conn, err = d.DialContext(ctx, "unix", path)
+	} else {
+		conn, err = d.DialContext(ctx, "tcp", c.proxy)
+	}
 	if err != nil {
 		return
 	}
+	stop := watchContext(ctx, conn)
 	defer func() {
+		stop()
 		if err != nil {
 			conn.Close()
+			if ctx.Err() != nil {
+				err = ctx.Err()
+			}
 		}
 	}()
+	if c.TLS != nil {
+		var tc net.Conn
+		tc, err = c.dialTLS(conn)
+		if err != nil {
+			return
+		}
+		conn = tc
+	}
 
-	err = Dial(conn, c.methods, c.auth, req)
+	methods := c.methods
+	if c.Compress {
+		methods = append([]Method{MethodDeflate}, methods...)
+	}
+	if c.Secret != nil && c.auth != nil {
+		methods = append([]Method{MethodHMAC}, methods...)
+	}
+	if c.PSK != nil {
+		methods = append([]Method{MethodAEAD}, methods...)
+	}
+	var rw io.ReadWriter
+	rw, err = c.handshake(conn, methods, req)
+	if err != nil {
+		return
+	}
+	if rw != io.ReadWriter(conn) {
+		conn = &encapConn{Conn: conn, rw: rw}
+	}
 	return
 }
 
+// watchContext interrupts the I/O of conn when ctx is done,
+// stop ends the watch and clears the deadline
+func watchContext(ctx context.Context, conn net.Conn) (stop func()) {
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	done := make(chan struct{})
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Unix(1, 0))
+		case <-done:
+		}
+	}()
+	return func() {
+		close(done)
+		<-exited
+		conn.SetDeadline(time.Time{})
+	}
+}
+
 // Dial connects to the provided address via SOCKS5 proxy
 func Dial(conn io.ReadWriter, methods []Method,
 	auth *Authentication, req *Request) (err error) {
+	_, err = (&Client{auth: auth}).handshake(conn, methods, req)
+	return
+}
+
+// handshake returns the connection or its encapsulation by the method
+func (c *Client) handshake(conn io.ReadWriter, methods []Method,
+	req *Request) (rw io.ReadWriter, err error) {
+	auth := c.auth
 	var method Method
 	err = sendMethods(conn, methods)
 	if err != nil {
@@ -69,9 +169,30 @@ func Dial(conn io.ReadWriter, methods []Method,
 	if err != nil {
 		return
 	}
+	// no fallback to the clear with a key
+	if c.PSK != nil {
+		if method != MethodAEAD {
+			err = fmt.Errorf("%w : %02x", ErrMethodNoAcceptable, method)
+			return
+		}
+		conn, err = connectAEAD(conn, c.PSK)
+		if err != nil {
+			return
+		}
+		method, err = readMethodSelection(conn)
+		if err != nil {
+			return
+		}
+	}
 
 	switch method {
 	case MethodNotRequired:
+	case MethodDeflate:
+		if !c.Compress {
+			err = fmt.Errorf("%w : %02x", ErrMethodNoAcceptable, method)
+			return
+		}
+		conn = newDeflateConn(conn, nil)
 	case MethodUsernamePassword:
 		if auth == nil {
 			err = ErrInvalidAuth
@@ -85,6 +206,19 @@ func Dial(conn io.ReadWriter, methods []Method,
 		if err != nil {
 			return
 		}
+	case MethodHMAC:
+		if auth == nil || c.Secret == nil {
+			err = ErrInvalidAuth
+			return
+		}
+		err = sendHMACResponse(conn, auth.Username, c.Secret)
+		if err != nil {
+			return
+		}
+		err = readAuthStatus(conn)
+		if err != nil {
+			return
+		}
 	default:
 		err = fmt.Errorf("%w : %02x", ErrMethodNoAcceptable, method)
 		return
@@ -100,7 +234,8 @@ func Dial(conn io.ReadWriter, methods []Method,
 		return
 	}
 	if rep.Code != ReplySucceed {
-		return fmt.Errorf("%w : %s", ErrReplyFailure, rep.Code.String())
+		err = fmt.Errorf("%w : %s", ErrReplyFailure, rep.Code.String())
+		return
 	}
-	return nil
+	return conn, nil
 }
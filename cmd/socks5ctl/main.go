@@ -0,0 +1,60 @@
+// Command socks5ctl manages the files of a socks5 server.
+//
+//	socks5ctl quota-report -f usage.json > report.csv
+//	socks5ctl user add -f users -groups staff alice
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+
+	"socks5"
+)
+
+var commands = map[string]func(args []string) error{
+	"quota-report": quotaReport,
+	"user":         user,
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		usage()
+	}
+	if err := cmd(os.Args[2:]); err != nil {
+		fmt.Fprintln(os.Stderr, "socks5ctl:", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	names := make([]string, 0, len(commands))
+	for n := range commands {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	fmt.Fprintln(os.Stderr, "usage: socks5ctl <command> [options]")
+	fmt.Fprintln(os.Stderr, "commands:")
+	for _, n := range names {
+		fmt.Fprintln(os.Stderr, "  "+n)
+	}
+	os.Exit(2)
+}
+
+// quotaReport writes the usage of a Quota file in CSV
+func quotaReport(args []string) error {
+	fs := flag.NewFlagSet("quota-report", flag.ExitOnError)
+	file := fs.String("f", "usage.json", "usage file of the quota")
+	fs.Parse(args)
+
+	q, err := socks5.LoadQuota(*file)
+	if err != nil {
+		return err
+	}
+	return q.WriteCSV(os.Stdout)
+}
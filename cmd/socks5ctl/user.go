@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"socks5"
+)
+
+// user edits a UserDB file, the password is read from stdin if -p is empty
+//
+//	socks5ctl user add -f users [-scheme sha512-crypt] [-groups a,b] [-disabled] <name>
+//	socks5ctl user passwd -f users [-scheme sha512-crypt] <name>
+//	socks5ctl user remove -f users <name>
+//	socks5ctl user totp -f users [-issuer socks5] [-off] <name>
+//
+// totp sets a new TOTP secret and prints its otpauth URI.
+func user(args []string) error {
+	if len(args) < 1 {
+		return errors.New("usage: socks5ctl user add|passwd|remove|totp [options] <name>")
+	}
+	action := args[0]
+	fs := flag.NewFlagSet("user "+action, flag.ExitOnError)
+	file := fs.String("f", "users", "user file")
+	scheme := fs.String("scheme", socks5.DefaultScheme, "password hash scheme")
+	password := fs.String("p", "", "password, read from stdin if empty")
+	groups := fs.String("groups", "", "comma separated groups")
+	disabled := fs.Bool("disabled", false, "disable the user")
+	issuer := fs.String("issuer", "socks5", "issuer of the otpauth URI")
+	off := fs.Bool("off", false, "remove the TOTP secret")
+	fs.Parse(args[1:])
+	if fs.NArg() != 1 {
+		return errors.New("a user name is required")
+	}
+	name := fs.Arg(0)
+
+	db, err := socks5.LoadUserDB(*file)
+	if os.IsNotExist(err) && action == "add" {
+		db, err = &socks5.UserDB{Filename: *file}, nil
+	}
+	if err != nil {
+		return err
+	}
+
+	switch action {
+	case "add":
+		u := &socks5.User{Name: name, Disabled: *disabled}
+		if *groups != "" {
+			u.Groups = strings.Split(*groups, ",")
+		}
+		if err := db.Add(u); err != nil {
+			return err
+		}
+		fallthrough
+	case "passwd":
+		if *password == "" {
+			if *password, err = readPassword(); err != nil {
+				return err
+			}
+		}
+		if err := db.SetPassword(name, *password, *scheme); err != nil {
+			return err
+		}
+	case "remove":
+		if err := db.Remove(name); err != nil {
+			return err
+		}
+	case "totp":
+		secret := ""
+		if !*off {
+			if secret, err = socks5.NewTOTPSecret(); err != nil {
+				return err
+			}
+		}
+		if err := db.SetTOTP(name, secret); err != nil {
+			return err
+		}
+		if secret != "" {
+			fmt.Println(socks5.TOTPURI(*issuer, name, secret))
+		}
+	default:
+		return fmt.Errorf("unknown action : %s", action)
+	}
+	return db.Save()
+}
+
+func readPassword() (string, error) {
+	fmt.Fprint(os.Stderr, "password: ")
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	line = strings.TrimRight(line, "\r\n")
+	if line == "" {
+		if err == nil {
+			err = errors.New("empty password")
+		}
+		return "", err
+	}
+	return line, nil
+}
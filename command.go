@@ -3,6 +3,7 @@ package socks5
 import (
 	"errors"
 	"fmt"
+	"strings"
 )
 
 // Command represents SOCKS5 Command
@@ -27,3 +28,15 @@ func getCommand(network string) (Command, error) {
 	}
 	return 0, fmt.Errorf("network not implemented : %s", network)
 }
+
+func parseCommand(s string) (Command, error) {
+	switch strings.ToLower(s) {
+	case "connect":
+		return CmdConnect, nil
+	case "bind":
+		return CmdBind, nil
+	case "udp":
+		return CmdUDP, nil
+	}
+	return 0, fmt.Errorf("%w : %s", ErrCmdUnsupported, s)
+}
@@ -0,0 +1,66 @@
+package socks5
+
+import (
+	"compress/flate"
+	"io"
+	"sync"
+)
+
+// MethodDeflate compresses the rest of the connection with DEFLATE,
+// each write is flushed so interactive traffic isn't delayed.
+// It replaces MethodNotRequired when both peers offer it.
+//
+// Server metrics: deflate_in_raw, deflate_in_wire, deflate_out_raw and
+// deflate_out_wire count the bytes read and written, before and after
+// compression.
+
+// deflateConn reads and writes the DEFLATE stream of MethodDeflate
+type deflateConn struct {
+	r io.Reader
+
+	mu sync.Mutex
+	w  *flate.Writer
+
+	metrics *Metrics
+}
+
+func newDeflateConn(rw io.ReadWriter, m *Metrics) io.ReadWriter {
+	// the error is only of an invalid level
+	w, _ := flate.NewWriter(&countingWriter{w: rw, count: func(n int) {
+		m.Add("deflate_out_wire", int64(n))
+	}}, flate.DefaultCompression)
+	return &deflateConn{
+		r: flate.NewReader(&countingReader{r: rw, count: func(n int) {
+			m.Add("deflate_in_wire", int64(n))
+		}}),
+		w:       w,
+		metrics: m,
+	}
+}
+
+func (c *deflateConn) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.metrics.Add("deflate_in_raw", int64(n))
+	return n, err
+}
+
+func (c *deflateConn) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n, err := c.w.Write(p)
+	if err != nil {
+		return n, err
+	}
+	c.metrics.Add("deflate_out_raw", int64(n))
+	return n, c.w.Flush()
+}
+
+// CompressionRatio returns the raw bytes per wire byte of MethodDeflate
+// in the metrics, zero if nothing is compressed
+func CompressionRatio(m *Metrics) float64 {
+	wire := m.Get("deflate_in_wire") + m.Get("deflate_out_wire")
+	if wire == 0 {
+		return 0
+	}
+	return float64(m.Get("deflate_in_raw")+m.Get("deflate_out_raw")) / float64(wire)
+}
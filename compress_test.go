@@ -0,0 +1,62 @@
+package socks5
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+)
+
+func TestDeflate(t *testing.T) {
+	echo := testEchoServer(t)
+	defer echo.Close()
+
+	s := NewServer()
+	s.Compress = true
+	s.Metrics = NewMetrics()
+	l, err := net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go s.Serve(l)
+
+	c, err := NewClient(l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Compress = true
+	conn, err := c.Dial("tcp", echo.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, ok := conn.(*encapConn); !ok {
+		t.Fatal(conn)
+	}
+
+	// every write is flushed
+	for _, msg := range [][]byte{[]byte("ls\n"), bytes.Repeat([]byte("compressible text "), 1000)} {
+		if _, err := conn.Write(msg); err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, len(msg))
+		if _, err := io.ReadFull(conn, buf); err != nil || !bytes.Equal(buf, msg) {
+			t.Fatal(err)
+		}
+	}
+	if s.Metrics.Get("deflate_in_raw") < 18003 || CompressionRatio(s.Metrics) < 10 {
+		t.Fatal(s.Metrics.String())
+	}
+
+	// only when both sides support it
+	s.Compress = false
+	plain, err := c.Dial("tcp", echo.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer plain.Close()
+	if _, ok := plain.(*encapConn); ok {
+		t.Fatal(plain)
+	}
+}
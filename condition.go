@@ -0,0 +1,262 @@
+package socks5
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Condition reports whether a request matches
+type Condition func(ctx context.Context, auth *Authentication, req *Request) bool
+
+// MatchDomainSuffix matches the domain and its subdomains
+func MatchDomainSuffix(suffix string) Condition {
+	suffix = strings.ToLower(strings.TrimPrefix(suffix, "."))
+	return func(ctx context.Context, auth *Authentication, req *Request) bool {
+		if req.Dst.Type != AddrTypeDN {
+			return false
+		}
+		d := strings.ToLower(strings.TrimSuffix(req.Dst.Domain, "."))
+		return d == suffix || strings.HasSuffix(d, "."+suffix)
+	}
+}
+
+// MatchWildcard matches the domain with a shell pattern, e.g. "*.example.*"
+func MatchWildcard(pattern string) (Condition, error) {
+	pattern = strings.ToLower(pattern)
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+	return func(ctx context.Context, auth *Authentication, req *Request) bool {
+		if req.Dst.Type != AddrTypeDN {
+			return false
+		}
+		ok, _ := path.Match(pattern, strings.ToLower(req.Dst.Domain))
+		return ok
+	}, nil
+}
+
+// MatchRegexp matches the destination host with a regular expression
+func MatchRegexp(expr string) (Condition, error) {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	return func(ctx context.Context, auth *Authentication, req *Request) bool {
+		return re.MatchString(req.Dst.Host())
+	}, nil
+}
+
+// MatchCIDR matches the destination IP, or the IP literal of a domain.
+// Other domain destinations never match.
+func MatchCIDR(cidr string) (Condition, error) {
+	n, err := parseCIDR(cidr)
+	if err != nil {
+		return nil, err
+	}
+	return func(ctx context.Context, auth *Authentication, req *Request) bool {
+		ip := dstIP(req)
+		return ip != nil && n.Contains(ip)
+	}, nil
+}
+
+// dstIP returns the destination IP, a domain of an IP literal
+// ("127.0.0.1" sent as a domain name) is the IP it's dialed as
+func dstIP(req *Request) net.IP {
+	if req.Dst.Type == AddrTypeDN {
+		return net.ParseIP(strings.TrimSuffix(req.Dst.Domain, "."))
+	}
+	return req.Dst.IP
+}
+
+// MatchPort matches the destination port with "80" or "8000-8080"
+func MatchPort(ports string) (Condition, error) {
+	lo, hi, err := parsePortRange(ports)
+	if err != nil {
+		return nil, err
+	}
+	return func(ctx context.Context, auth *Authentication, req *Request) bool {
+		return req.Dst.Port >= lo && req.Dst.Port <= hi
+	}, nil
+}
+
+// MatchCommand matches the request command
+func MatchCommand(cmd Command) Condition {
+	return func(ctx context.Context, auth *Authentication, req *Request) bool {
+		return req.Cmd == cmd
+	}
+}
+
+// MatchHost matches the destination host exactly
+func MatchHost(host string) Condition {
+	if ip := net.ParseIP(host); ip != nil {
+		return func(ctx context.Context, auth *Authentication, req *Request) bool {
+			return ip.Equal(dstIP(req))
+		}
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	return func(ctx context.Context, auth *Authentication, req *Request) bool {
+		return req.Dst.Type == AddrTypeDN &&
+			strings.ToLower(strings.TrimSuffix(req.Dst.Domain, ".")) == host
+	}
+}
+
+// MatchSource matches the IP of the client connection
+func MatchSource(cidr string) (Condition, error) {
+	n, err := parseCIDR(cidr)
+	if err != nil {
+		return nil, err
+	}
+	return func(ctx context.Context, auth *Authentication, req *Request) bool {
+		ip := ClientIP(ctx)
+		return ip != nil && n.Contains(ip)
+	}, nil
+}
+
+// MatchUser matches the authenticated username
+func MatchUser(username string) Condition {
+	return func(ctx context.Context, auth *Authentication, req *Request) bool {
+		return auth != nil && string(auth.Username) == username
+	}
+}
+
+// MatchGroup matches the authenticated users of the group
+func MatchGroup(group string) Condition {
+	return MatchAttribute(AttributeGroup, group)
+}
+
+// MatchAttribute matches the authenticated users
+// having the value in the attribute
+func MatchAttribute(key, value string) Condition {
+	return func(ctx context.Context, auth *Authentication, req *Request) bool {
+		if auth == nil {
+			return false
+		}
+		for _, v := range auth.Attributes[key] {
+			if v == value {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// ParseCondition parses a condition in "key:value" form.
+//
+//	host:example.com
+//	suffix:example.com
+//	wildcard:*.example.*
+//	regex:^api[0-9]+\.
+//	cidr:10.0.0.0/8
+//	port:8000-8080
+//	cmd:connect
+//	src:192.168.0.0/16
+//	user:alice
+//	group:admin
+//	attr:class=premium
+//	param:exit=us
+func ParseCondition(s string) (Condition, error) {
+	i := strings.IndexByte(s, ':')
+	if i < 0 {
+		return nil, fmt.Errorf("invalid condition : %s", s)
+	}
+	key, value := s[:i], s[i+1:]
+	switch key {
+	case "host":
+		return MatchHost(value), nil
+	case "suffix":
+		return MatchDomainSuffix(value), nil
+	case "wildcard":
+		return MatchWildcard(value)
+	case "regex":
+		return MatchRegexp(value)
+	case "cidr":
+		return MatchCIDR(value)
+	case "port":
+		return MatchPort(value)
+	case "cmd":
+		cmd, err := parseCommand(value)
+		if err != nil {
+			return nil, err
+		}
+		return MatchCommand(cmd), nil
+	case "src":
+		return MatchSource(value)
+	case "user":
+		return MatchUser(value), nil
+	case "group":
+		return MatchGroup(value), nil
+	case "attr":
+		i := strings.IndexByte(value, '=')
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid condition : %s", s)
+		}
+		return MatchAttribute(value[:i], value[i+1:]), nil
+	case "param":
+		i := strings.IndexByte(value, '=')
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid condition : %s", s)
+		}
+		return MatchParam(value[:i], value[i+1:]), nil
+	}
+	return nil, fmt.Errorf("unknown condition : %s", key)
+}
+
+func parseConditions(fields []string) ([]Condition, error) {
+	conds := make([]Condition, 0, len(fields))
+	for _, f := range fields {
+		c, err := ParseCondition(f)
+		if err != nil {
+			return nil, err
+		}
+		conds = append(conds, c)
+	}
+	return conds, nil
+}
+
+func matchAll(ctx context.Context, conds []Condition,
+	auth *Authentication, req *Request) bool {
+	for _, c := range conds {
+		if !c(ctx, auth, req) {
+			return false
+		}
+	}
+	return true
+}
+
+// parseCIDR also accepts a single IP
+func parseCIDR(s string) (*net.IPNet, error) {
+	if !strings.Contains(s, "/") {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid CIDR : %s", s)
+		}
+		if ip4 := ip.To4(); ip4 != nil {
+			return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+	}
+	_, n, err := net.ParseCIDR(s)
+	return n, err
+}
+
+func parsePortRange(s string) (lo, hi uint16, err error) {
+	parts := strings.SplitN(s, "-", 2)
+	p, err := strconv.ParseUint(parts[0], 10, 16)
+	if err != nil {
+		return 0, 0, fmt.Errorf("%w : %s", ErrInvalidPort, s)
+	}
+	lo, hi = uint16(p), uint16(p)
+	if len(parts) == 2 {
+		p, err = strconv.ParseUint(parts[1], 10, 16)
+		if err != nil || uint16(p) < lo {
+			return 0, 0, fmt.Errorf("%w : %s", ErrInvalidPort, s)
+		}
+		hi = uint16(p)
+	}
+	return lo, hi, nil
+}
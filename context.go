@@ -0,0 +1,119 @@
+package socks5
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+type contextKey struct {
+	name string
+}
+
+var connInfoKey = &contextKey{"conn-info"}
+
+// connInfo holds the values of a client connection
+type connInfo struct {
+	server *Server
+	remote net.Addr
+	local  net.Addr
+	auth   *Authentication
+	dialer *Dialer
+	dialed net.Addr
+
+	// session keeps the sticky choices of SourcePool for sessionTTL,
+	// StickyTTL if zero
+	session    string
+	sessionTTL time.Duration
+
+	// egress is the identity of Server.Isolation
+	egress *egress
+
+	resolver    Resolver
+	resolveTime time.Duration
+
+	// release frees the connection limit slots
+	release []func()
+}
+
+func withConnInfo(ctx context.Context, conn interface{}) context.Context {
+	if _, ok := ctx.Value(connInfoKey).(*connInfo); ok {
+		return ctx
+	}
+	info := &connInfo{}
+	if c, ok := conn.(interface{ RemoteAddr() net.Addr }); ok {
+		info.remote = c.RemoteAddr()
+	}
+	if c, ok := conn.(interface{ LocalAddr() net.Addr }); ok {
+		info.local = c.LocalAddr()
+	}
+	return context.WithValue(ctx, connInfoKey, info)
+}
+
+func (info *connInfo) metrics() *Metrics {
+	if info.server == nil {
+		return nil
+	}
+	return info.server.Metrics
+}
+
+// sessionKey is the session, or the username and client IP
+func (info *connInfo) sessionKey() string {
+	if info.session != "" {
+		return info.session
+	}
+	key := ""
+	if info.auth != nil {
+		key = string(info.auth.Username)
+	}
+	if info.remote != nil {
+		host, _, err := net.SplitHostPort(info.remote.String())
+		if err != nil {
+			host = info.remote.String()
+		}
+		key += "@" + host
+	}
+	return key
+}
+
+func (info *connInfo) releaseAll() {
+	for _, release := range info.release {
+		release()
+	}
+	info.release = nil
+}
+
+func getConnInfo(ctx context.Context) *connInfo {
+	if info, ok := ctx.Value(connInfoKey).(*connInfo); ok {
+		return info
+	}
+	return &connInfo{}
+}
+
+// ClientAddr returns the address of the client connection,
+// nil if the connection has no address.
+func ClientAddr(ctx context.Context) net.Addr {
+	return getConnInfo(ctx).remote
+}
+
+// ClientIP returns the IP of the client connection, nil if unknown
+func ClientIP(ctx context.Context) net.IP {
+	return getConnInfo(ctx).clientIP()
+}
+
+func (info *connInfo) clientIP() net.IP {
+	switch a := info.remote.(type) {
+	case *net.TCPAddr:
+		return a.IP
+	case *net.UDPAddr:
+		return a.IP
+	case nil:
+		return nil
+	default:
+		host, _, err := net.SplitHostPort(a.String())
+		if err != nil {
+			return nil
+		}
+		return net.ParseIP(host)
+	}
+}
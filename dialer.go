@@ -0,0 +1,307 @@
+package socks5
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"syscall"
+	"time"
+)
+
+// DefaultFallbackDelay is the Connection Attempt Delay of RFC 8305
+const DefaultFallbackDelay = 250 * time.Millisecond
+
+// Dialer opens the outbound connections for CONNECT requests
+type Dialer struct {
+	// Guard checks every resolved IP before dialing, nil allows all
+	Guard *Guard
+
+	// FallbackDelay is the delay before the next address is attempted
+	// while the previous attempt is pending (RFC 8305 Happy Eyeballs).
+	// Zero uses DefaultFallbackDelay, negative dials one by one.
+	FallbackDelay time.Duration
+
+	// Source binds the outbound connections to local addresses
+	Source *SourcePool
+
+	// Socket options of outbound connections, Linux only
+	Mark        int           // SO_MARK, the fwmark for policy routing
+	Device      string        // SO_BINDTODEVICE
+	UserTimeout time.Duration // TCP_USER_TIMEOUT
+	FastOpen    bool          // TCP_FASTOPEN_CONNECT, ignored if unsupported
+
+	// KeepAlive is the TCP keep-alive period,
+	// zero uses the default of net.Dialer, negative disables it.
+	KeepAlive time.Duration
+
+	// Control is called after the socket options are set
+	Control func(network, address string, c syscall.RawConn) error
+
+	// dial is replaced in tests
+	dial func(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+var defaultDialer = &Dialer{}
+
+func dialerFromContext(ctx context.Context) *Dialer {
+	if d := getConnInfo(ctx).dialer; d != nil {
+		return d
+	}
+	return defaultDialer
+}
+
+// HandleRequest handles the request with the dialer
+func (d *Dialer) HandleRequest(ctx context.Context, auth *Authentication, req *Request) (
+	*Reply, io.ReadWriteCloser, error) {
+	switch req.Cmd {
+	case CmdConnect:
+		return d.handleConnect(ctx, req.Dst.String())
+	case CmdBind:
+	case CmdUDP:
+	default:
+	}
+	return nil, nil, ErrCmdUnsupported
+}
+
+func (d *Dialer) handleConnect(ctx context.Context, addr string) (
+	reply *Reply, target io.ReadWriteCloser, err error) {
+	var conn net.Conn
+	conn, err = d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		reply, _ = newReply(getReplyCode(err), "0.0.0.0:0")
+		return
+	}
+	reply, err = newReply(ReplySucceed, conn.LocalAddr().String())
+	if err != nil {
+		conn.Close()
+		return
+	}
+	target = conn
+	return
+}
+
+// DialContext resolves the address and races the IPs vetted by Guard
+func (d *Dialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	ips, err := d.lookup(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	allowed := make([]net.IP, 0, len(ips))
+	for _, ip := range ips {
+		if err = d.Guard.Check(ip); err == nil {
+			allowed = append(allowed, ip)
+		}
+	}
+	if len(allowed) == 0 {
+		return nil, err
+	}
+
+	conn, err := d.dialParallel(ctx, network, interleave(allowed), port)
+	if err != nil {
+		return nil, err
+	}
+	info := getConnInfo(ctx)
+	info.dialed = conn.RemoteAddr()
+	if a, ok := conn.RemoteAddr().(*net.TCPAddr); ok && a.IP.To4() == nil {
+		info.metrics().Add("connect_ipv6", 1)
+	} else {
+		info.metrics().Add("connect_ipv4", 1)
+	}
+	return conn, nil
+}
+
+// dialParallel races the addresses, starting the next one
+// after FallbackDelay or as soon as an attempt fails.
+// The first connection wins and the other attempts are canceled.
+func (d *Dialer) dialParallel(ctx context.Context,
+	network string, ips []net.IP, port string) (net.Conn, error) {
+	delay := d.FallbackDelay
+	if delay == 0 {
+		delay = DefaultFallbackDelay
+	}
+	if delay < 0 || len(ips) == 1 {
+		var err error
+		for _, ip := range ips {
+			var conn net.Conn
+			conn, err = d.dialIP(ctx, network, ip, port)
+			if err == nil {
+				return conn, nil
+			}
+		}
+		return nil, err
+	}
+
+	type result struct {
+		conn net.Conn
+		err  error
+		i    int
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results := make(chan result, len(ips))
+	next, pending := 0, 0
+	start := func() {
+		i, ip := next, ips[next]
+		next++
+		pending++
+		go func() {
+			conn, err := d.dialIP(ctx, network, ip, port)
+			results <- result{conn, err, i}
+		}()
+	}
+
+	start()
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	var err error
+	for pending > 0 {
+		select {
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				if r.i > 0 {
+					getConnInfo(ctx).metrics().Add("happy_eyeballs_fallback", 1)
+				}
+				go func(n int) {
+					for ; n > 0; n-- {
+						if r := <-results; r.conn != nil {
+							r.conn.Close()
+						}
+					}
+				}(pending)
+				return r.conn, nil
+			}
+			if err == nil {
+				err = r.err
+			}
+			if next < len(ips) {
+				start()
+				if !timer.Stop() {
+					select {
+					case <-timer.C:
+					default:
+					}
+				}
+				timer.Reset(delay)
+			}
+		case <-timer.C:
+			if next < len(ips) {
+				start()
+				timer.Reset(delay)
+			}
+		}
+	}
+	return nil, err
+}
+
+func (d *Dialer) dialIP(ctx context.Context,
+	network string, ip net.IP, port string) (net.Conn, error) {
+	address := net.JoinHostPort(ip.String(), port)
+	if d.dial != nil {
+		return d.dial(ctx, network, address)
+	}
+	nd := net.Dialer{KeepAlive: d.KeepAlive}
+	src, free, err := d.Source.selectSource(ctx, ip)
+	if err != nil {
+		return nil, err
+	}
+	if src != nil {
+		nd.LocalAddr = &net.TCPAddr{IP: src}
+	}
+	nd.Control = d.control(free)
+	return nd.DialContext(ctx, network, address)
+}
+
+func (d *Dialer) control(free bool) func(network, address string, c syscall.RawConn) error {
+	sockopts := d.Mark != 0 || d.Device != "" || d.UserTimeout > 0 || d.FastOpen
+	if !free && !sockopts && d.Control == nil {
+		return nil
+	}
+	return func(network, address string, c syscall.RawConn) error {
+		if free {
+			if err := freebind(network, address, c); err != nil {
+				return err
+			}
+		}
+		if sockopts {
+			if err := setSockopts(c, d.Mark, d.Device, d.UserTimeout, d.FastOpen); err != nil {
+				return err
+			}
+		}
+		if d.Control != nil {
+			return d.Control(network, address, c)
+		}
+		return nil
+	}
+}
+
+// interleave alternates the address families,
+// starting with the family of the first address
+func interleave(ips []net.IP) []net.IP {
+	var first, second []net.IP
+	v4 := ips[0].To4() != nil
+	for _, ip := range ips {
+		if (ip.To4() != nil) == v4 {
+			first = append(first, ip)
+		} else {
+			second = append(second, ip)
+		}
+	}
+	sorted := make([]net.IP, 0, len(ips))
+	for i := 0; i < len(first) || i < len(second); i++ {
+		if i < len(first) {
+			sorted = append(sorted, first[i])
+		}
+		if i < len(second) {
+			sorted = append(sorted, second[i])
+		}
+	}
+	return sorted
+}
+
+func (d *Dialer) lookup(ctx context.Context, host string) ([]net.IP, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}, nil
+	}
+	info := getConnInfo(ctx)
+	r := info.resolver
+	if r == nil {
+		r = systemResolver{}
+	}
+	start := time.Now()
+	ips, err := r.LookupIP(ctx, host)
+	info.resolveTime += time.Since(start)
+	if err != nil {
+		return nil, err
+	}
+	if len(ips) == 0 {
+		return nil, &ReplyError{Code: ReplyHostUnreachable,
+			Err: fmt.Errorf("%w : %s", ErrNoSuchHost, host)}
+	}
+	return ips, nil
+}
+
+// systemResolver resolves with net.DefaultResolver without caching
+type systemResolver struct{}
+
+func (systemResolver) LookupIP(ctx context.Context, host string) ([]net.IP, error) {
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		var de *net.DNSError
+		if errors.As(err, &de) && de.IsNotFound {
+			return nil, &ReplyError{Code: ReplyHostUnreachable, Err: err}
+		}
+		return nil, err
+	}
+	ips := make([]net.IP, len(addrs))
+	for i, a := range addrs {
+		ips[i] = a.IP
+	}
+	return ips, nil
+}
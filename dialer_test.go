@@ -0,0 +1,97 @@
+package socks5
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+type testResolver map[string][]net.IP
+
+func (r testResolver) LookupIP(ctx context.Context, host string) ([]net.IP, error) {
+	return r[host], nil
+}
+
+func TestInterleave(t *testing.T) {
+	ips := []net.IP{
+		net.ParseIP("2001:db8::1"),
+		net.ParseIP("2001:db8::2"),
+		net.ParseIP("192.0.2.1"),
+		net.ParseIP("2001:db8::3"),
+	}
+	want := []string{"2001:db8::1", "192.0.2.1", "2001:db8::2", "2001:db8::3"}
+	for i, ip := range interleave(ips) {
+		if ip.String() != want[i] {
+			t.Fatal(i, ip)
+		}
+	}
+}
+
+func TestHappyEyeballs(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+
+	// 2001:db8::1 is blackholed, 127.0.0.2 refuses
+	var nd net.Dialer
+	d := &Dialer{
+		FallbackDelay: 100 * time.Millisecond,
+		dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			if host, _, _ := net.SplitHostPort(address); host == "2001:db8::1" {
+				<-ctx.Done()
+				return nil, ctx.Err()
+			}
+			return nd.DialContext(ctx, network, address)
+		},
+	}
+	s := NewServer()
+	s.Dialer = d
+	s.Metrics = NewMetrics()
+	s.Resolver = testResolver{
+		"broken6.test": {net.ParseIP("2001:db8::1"), net.ParseIP("127.0.0.1")},
+		"refused.test": {net.ParseIP("127.0.0.2"), net.ParseIP("127.0.0.1")},
+	}
+
+	start := time.Now()
+	event, err := testHandshake(s, "broken6.test:"+port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	event.Target.Close()
+	if elapsed := time.Since(start); elapsed < d.FallbackDelay || elapsed > time.Second {
+		t.Fatal(elapsed)
+	}
+	if event.Dialed.String() != l.Addr().String() {
+		t.Fatal(event.Dialed)
+	}
+	if s.Metrics.Get("happy_eyeballs_fallback") != 1 || s.Metrics.Get("connect_ipv4") != 1 {
+		t.Fatal(s.Metrics.String())
+	}
+
+	// a failed attempt starts the next one at once
+	d.FallbackDelay = 10 * time.Second
+	start = time.Now()
+	event, err = testHandshake(s, "refused.test:"+port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	event.Target.Close()
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatal(elapsed)
+	}
+}
+
+func testHandshake(s *Server, addr string) (Event, error) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	go func() {
+		req, _ := newRequest("tcp", addr)
+		Dial(c1, []Method{MethodNotRequired}, nil, req)
+	}()
+	return s.Handshake(context.Background(), c2)
+}
@@ -0,0 +1,135 @@
+package socks5
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"strings"
+)
+
+// DNS message constants, see RFC 1035
+const (
+	dnsTypeA    uint16 = 1
+	dnsTypeSOA  uint16 = 6
+	dnsTypeAAAA uint16 = 28
+	dnsClassIN  uint16 = 1
+
+	dnsRcodeSuccess  = 0
+	dnsRcodeNXDomain = 3
+)
+
+var errDNSMessage = errors.New("invalid DNS message")
+
+type dnsAnswer struct {
+	rcode     int
+	truncated bool
+	ips       []net.IP
+	ttl       uint32 // the minimum TTL of the answers
+	negTTL    uint32 // from SOA of authority section, 0 if absent
+}
+
+func newDNSQuery(id uint16, host string, qtype uint16) ([]byte, error) {
+	buf := make([]byte, 12, 12+len(host)+6)
+	binary.BigEndian.PutUint16(buf[0:], id)
+	binary.BigEndian.PutUint16(buf[2:], 0x0100) // recursion desired
+	binary.BigEndian.PutUint16(buf[4:], 1)
+	for _, label := range strings.Split(strings.TrimSuffix(host, "."), ".") {
+		if len(label) == 0 || len(label) > 63 {
+			return nil, ErrInvalidDomainName
+		}
+		buf = append(buf, byte(len(label)))
+		buf = append(buf, label...)
+	}
+	buf = append(buf, 0, 0, 0, 0, 0)
+	binary.BigEndian.PutUint16(buf[len(buf)-4:], qtype)
+	binary.BigEndian.PutUint16(buf[len(buf)-2:], dnsClassIN)
+	return buf, nil
+}
+
+func parseDNSAnswer(msg []byte, id uint16) (*dnsAnswer, error) {
+	if len(msg) < 12 || binary.BigEndian.Uint16(msg) != id {
+		return nil, errDNSMessage
+	}
+	flags := binary.BigEndian.Uint16(msg[2:])
+	if flags&0x8000 == 0 {
+		return nil, errDNSMessage
+	}
+	a := &dnsAnswer{
+		rcode:     int(flags & 0x000f),
+		truncated: flags&0x0200 != 0,
+	}
+	qd := int(binary.BigEndian.Uint16(msg[4:]))
+	an := int(binary.BigEndian.Uint16(msg[6:]))
+	ns := int(binary.BigEndian.Uint16(msg[8:]))
+
+	off := 12
+	var err error
+	for i := 0; i < qd; i++ {
+		if off, err = skipDNSName(msg, off); err != nil {
+			return nil, err
+		}
+		off += 4
+	}
+	for i := 0; i < an+ns; i++ {
+		if off, err = skipDNSName(msg, off); err != nil {
+			return nil, err
+		}
+		if off+10 > len(msg) {
+			return nil, errDNSMessage
+		}
+		typ := binary.BigEndian.Uint16(msg[off:])
+		ttl := binary.BigEndian.Uint32(msg[off+4:])
+		rdlen := int(binary.BigEndian.Uint16(msg[off+8:]))
+		off += 10
+		if off+rdlen > len(msg) {
+			return nil, errDNSMessage
+		}
+		rdata := msg[off : off+rdlen]
+
+		if i < an {
+			switch {
+			case typ == dnsTypeA && rdlen == net.IPv4len,
+				typ == dnsTypeAAAA && rdlen == net.IPv6len:
+				a.ips = append(a.ips, net.IP(append([]byte(nil), rdata...)))
+			default:
+				off += rdlen
+				continue
+			}
+			if len(a.ips) == 1 || ttl < a.ttl {
+				a.ttl = ttl
+			}
+		} else if typ == dnsTypeSOA {
+			// mname, rname, serial, refresh, retry, expire, minimum
+			p, err := skipDNSName(msg, off)
+			if err == nil {
+				p, err = skipDNSName(msg, p)
+			}
+			if err == nil && p+20 <= off+rdlen {
+				a.negTTL = binary.BigEndian.Uint32(msg[p+16:])
+				if ttl < a.negTTL {
+					a.negTTL = ttl
+				}
+			}
+		}
+		off += rdlen
+	}
+	return a, nil
+}
+
+func skipDNSName(msg []byte, off int) (int, error) {
+	for {
+		if off >= len(msg) {
+			return 0, errDNSMessage
+		}
+		l := int(msg[off])
+		switch {
+		case l == 0:
+			return off + 1, nil
+		case l&0xc0 == 0xc0:
+			return off + 2, nil
+		case l&0xc0 != 0:
+			return 0, errDNSMessage
+		}
+		off += 1 + l
+	}
+}
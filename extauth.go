@@ -0,0 +1,250 @@
+package socks5
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Default values of ExecAuth and WebhookAuth
+const (
+	DefaultExtAuthTimeout       = 5 * time.Second
+	DefaultExtAuthMaxConcurrent = 8
+)
+
+// ErrExtAuth represents an external authenticator failed
+var ErrExtAuth = errors.New("external authentication failed")
+
+// extAuth runs the checks of an external authenticator
+// with a timeout, a concurrency limit and a result cache
+type extAuth struct {
+	once  sync.Once
+	sem   chan struct{}
+	cache authCache
+}
+
+type extCheck func(ctx context.Context) (attrs map[string][]string, allow bool, err error)
+
+func (e *extAuth) check(ctx context.Context, username, password string,
+	timeout time.Duration, max int, ttl time.Duration, fn extCheck) (map[string][]string, error) {
+	if attrs, ok, found := e.cache.get(username, password); found {
+		if !ok {
+			return nil, ErrAuthFailed
+		}
+		return attrs, nil
+	}
+	e.once.Do(func() {
+		if max <= 0 {
+			max = DefaultExtAuthMaxConcurrent
+		}
+		e.sem = make(chan struct{}, max)
+	})
+	if timeout <= 0 {
+		timeout = DefaultExtAuthTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	select {
+	case e.sem <- struct{}{}:
+		defer func() { <-e.sem }()
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	attrs, allow, err := fn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	e.cache.put(username, password, attrs, allow, ttl)
+	if !allow {
+		return nil, ErrAuthFailed
+	}
+	return attrs, nil
+}
+
+// ExecAuth authenticates with a program. The username and the password
+// are written to its stdin, one per line, credentials with a newline or
+// a NUL are denied without running it. The client address is
+// in the SOCKS5_CLIENT environment variable. Exit status 0 allows,
+// other statuses deny. The program may write the attributes
+// to stdout in JSON, e.g. {"group": ["staff"], "class": "premium"}.
+type ExecAuth struct {
+	Path string
+	Args []string
+
+	Timeout       time.Duration
+	MaxConcurrent int
+
+	// CacheTTL caches the results, zero disables it
+	CacheTTL time.Duration
+
+	ext extAuth
+}
+
+// Authenticate is a Server.Authenticate
+func (a *ExecAuth) Authenticate(ctx context.Context, auth *Authentication) bool {
+	if auth == nil {
+		return false
+	}
+	attrs, err := a.Check(ctx, string(auth.Username), string(auth.Password))
+	if err != nil {
+		return false
+	}
+	auth.Attributes = attrs
+	return true
+}
+
+// Check runs the program, it returns the attributes
+// or ErrAuthFailed if the program denies.
+func (a *ExecAuth) Check(ctx context.Context, username, password string) (map[string][]string, error) {
+	// a newline would shift the lines of the program
+	if strings.ContainsAny(username, "\n\x00") || strings.ContainsAny(password, "\n\x00") {
+		return nil, ErrAuthFailed
+	}
+	client := ""
+	if addr := ClientAddr(ctx); addr != nil {
+		client = addr.String()
+	}
+	return a.ext.check(ctx, username, password, a.Timeout, a.MaxConcurrent, a.CacheTTL,
+		func(ctx context.Context) (map[string][]string, bool, error) {
+			cmd := exec.CommandContext(ctx, a.Path, a.Args...)
+			cmd.Stdin = bytes.NewBufferString(username + "\n" + password + "\n")
+			cmd.Env = append(os.Environ(), "SOCKS5_CLIENT="+client)
+			var stdout bytes.Buffer
+			cmd.Stdout = &stdout
+			err := cmd.Run()
+			if ctx.Err() != nil {
+				return nil, false, ctx.Err()
+			}
+			var exit *exec.ExitError
+			if errors.As(err, &exit) {
+				return nil, false, nil
+			}
+			if err != nil {
+				return nil, false, fmt.Errorf("%w : %v", ErrExtAuth, err)
+			}
+			attrs, err := parseAttributes(stdout.Bytes())
+			if err != nil {
+				return nil, false, err
+			}
+			return attrs, true, nil
+		})
+}
+
+// WebhookAuth authenticates with an HTTP endpoint. It POSTs
+//
+//	{"username": "alice", "password": "secret", "client": "192.0.2.1:5000"}
+//
+// and expects a response of status 200
+//
+//	{"allow": true, "attributes": {"group": ["staff"]}}
+type WebhookAuth struct {
+	URL    string
+	Client *http.Client // http.DefaultClient if nil
+
+	Timeout       time.Duration
+	MaxConcurrent int
+
+	// CacheTTL caches the results, zero disables it
+	CacheTTL time.Duration
+
+	ext extAuth
+}
+
+// Authenticate is a Server.Authenticate
+func (a *WebhookAuth) Authenticate(ctx context.Context, auth *Authentication) bool {
+	if auth == nil {
+		return false
+	}
+	attrs, err := a.Check(ctx, string(auth.Username), string(auth.Password))
+	if err != nil {
+		return false
+	}
+	auth.Attributes = attrs
+	return true
+}
+
+// Check posts the credentials, it returns the attributes
+// or ErrAuthFailed if the endpoint denies.
+func (a *WebhookAuth) Check(ctx context.Context, username, password string) (map[string][]string, error) {
+	client := ""
+	if addr := ClientAddr(ctx); addr != nil {
+		client = addr.String()
+	}
+	return a.ext.check(ctx, username, password, a.Timeout, a.MaxConcurrent, a.CacheTTL,
+		func(ctx context.Context) (map[string][]string, bool, error) {
+			body, err := json.Marshal(map[string]string{
+				"username": username,
+				"password": password,
+				"client":   client,
+			})
+			if err != nil {
+				return nil, false, err
+			}
+			req, err := http.NewRequest(http.MethodPost, a.URL, bytes.NewReader(body))
+			if err != nil {
+				return nil, false, err
+			}
+			req = req.WithContext(ctx)
+			req.Header.Set("Content-Type", "application/json")
+			c := a.Client
+			if c == nil {
+				c = http.DefaultClient
+			}
+			resp, err := c.Do(req)
+			if err != nil {
+				return nil, false, fmt.Errorf("%w : %v", ErrExtAuth, err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				return nil, false, fmt.Errorf("%w : %s", ErrExtAuth, resp.Status)
+			}
+			var result struct {
+				Allow      bool            `json:"allow"`
+				Attributes json.RawMessage `json:"attributes"`
+			}
+			if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+				return nil, false, fmt.Errorf("%w : %v", ErrExtAuth, err)
+			}
+			if !result.Allow {
+				return nil, false, nil
+			}
+			attrs, err := parseAttributes(result.Attributes)
+			if err != nil {
+				return nil, false, err
+			}
+			return attrs, true, nil
+		})
+}
+
+// parseAttributes parses a JSON object of strings or lists of strings
+func parseAttributes(b []byte) (map[string][]string, error) {
+	if len(bytes.TrimSpace(b)) == 0 || string(bytes.TrimSpace(b)) == "null" {
+		return nil, nil
+	}
+	var raw map[string]interface{}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return nil, fmt.Errorf("%w : invalid attributes : %v", ErrExtAuth, err)
+	}
+	attrs := make(map[string][]string, len(raw))
+	for k, v := range raw {
+		switch v := v.(type) {
+		case []interface{}:
+			for _, i := range v {
+				attrs[k] = append(attrs[k], fmt.Sprint(i))
+			}
+		case nil:
+		default:
+			attrs[k] = []string{fmt.Sprint(v)}
+		}
+	}
+	return attrs, nil
+}
@@ -0,0 +1,106 @@
+package socks5
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestExecAuth(t *testing.T) {
+	dir, err := ioutil.TempDir("", "socks5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	script := filepath.Join(dir, "auth.sh")
+	runs := filepath.Join(dir, "runs")
+	err = ioutil.WriteFile(script, []byte(`#!/bin/sh
+echo run >> `+runs+`
+read username
+read password
+[ "$password" = "sleep" ] && exec sleep 5
+[ "$username" = "alice" ] && [ "$password" = "secret" ] || exit 1
+echo '{"group": ["staff", "dev"], "level": 3}'
+`), 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := &ExecAuth{Path: script, CacheTTL: time.Minute, Timeout: 500 * time.Millisecond}
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		attrs, err := a.Check(ctx, "alice", "secret")
+		if err != nil || len(attrs[AttributeGroup]) != 2 || attrs["level"][0] != "3" {
+			t.Fatal(attrs, err)
+		}
+		if _, err := a.Check(ctx, "alice", "wrong"); !errors.Is(err, ErrAuthFailed) {
+			t.Fatal(err)
+		}
+	}
+	for _, username := range []string{"alice\nsecret", "alice\x00"} {
+		if _, err := a.Check(ctx, username, "any"); !errors.Is(err, ErrAuthFailed) {
+			t.Fatal(err)
+		}
+	}
+	if b, _ := ioutil.ReadFile(runs); strings.Count(string(b), "run") != 2 {
+		t.Fatal(string(b))
+	}
+	if _, err := a.Check(ctx, "alice", "sleep"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal(err)
+	}
+}
+
+func TestWebhookAuth(t *testing.T) {
+	var calls, active, peak int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		if n := atomic.AddInt32(&active, 1); n > atomic.LoadInt32(&peak) {
+			atomic.StoreInt32(&peak, n)
+		}
+		defer atomic.AddInt32(&active, -1)
+		time.Sleep(20 * time.Millisecond)
+		var req map[string]string
+		json.NewDecoder(r.Body).Decode(&req)
+		if req["password"] != "secret" {
+			w.Write([]byte(`{"allow": false}`))
+			return
+		}
+		w.Write([]byte(`{"allow": true, "attributes": {"class": "premium"}}`))
+	}))
+	defer ts.Close()
+
+	a := &WebhookAuth{URL: ts.URL, MaxConcurrent: 2}
+	ctx := context.Background()
+	done := make(chan bool)
+	for i := 0; i < 6; i++ {
+		go func() {
+			auth := &Authentication{Username: []byte("alice"), Password: []byte("secret")}
+			done <- a.Authenticate(ctx, auth) && MatchAttribute("class", "premium")(ctx, auth, nil)
+		}()
+	}
+	for i := 0; i < 6; i++ {
+		if !<-done {
+			t.Fatal("Error")
+		}
+	}
+	if atomic.LoadInt32(&peak) > 2 {
+		t.Fatal(peak)
+	}
+	if _, err := a.Check(ctx, "alice", "wrong"); !errors.Is(err, ErrAuthFailed) {
+		t.Fatal(err)
+	}
+
+	a.URL = ts.URL + "/%zz"
+	if _, err := a.Check(ctx, "alice", "secret"); err == nil || strings.Contains(err.Error(), "secret") {
+		t.Fatal(err)
+	}
+}
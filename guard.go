@@ -0,0 +1,95 @@
+package socks5
+
+import (
+	"errors"
+	"fmt"
+	"net"
+)
+
+// ErrAddressDenied represents the destination IP is denied by Guard
+var ErrAddressDenied = errors.New("destination address denied")
+
+// DefaultDenyRanges are the loopback, private, link-local
+// and other special purpose networks
+var DefaultDenyRanges = []string{
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"172.16.0.0/12",
+	"192.0.0.0/24",
+	"192.168.0.0/16",
+	"198.18.0.0/15",
+	"224.0.0.0/4",
+	"240.0.0.0/4",
+	"::/128",
+	"::1/128",
+	"64:ff9b::/96",
+	"fc00::/7",
+	"fe80::/10",
+	"ff00::/8",
+}
+
+// Guard protects internal services from server-side request forgery.
+// Domain names are resolved once, every candidate IP is checked
+// and only the vetted IPs are dialed, so DNS rebinding can't slip past.
+type Guard struct {
+	Deny  []*net.IPNet
+	Allow []*net.IPNet // exceptions of Deny
+}
+
+// NewGuard creates a Guard with DefaultDenyRanges
+func NewGuard() *Guard {
+	g := &Guard{}
+	for _, r := range DefaultDenyRanges {
+		if err := g.DenyCIDR(r); err != nil {
+			panic(err)
+		}
+	}
+	return g
+}
+
+// DenyCIDR adds a denied network
+func (g *Guard) DenyCIDR(cidr string) error {
+	n, err := parseCIDR(cidr)
+	if err != nil {
+		return err
+	}
+	g.Deny = append(g.Deny, n)
+	return nil
+}
+
+// AllowCIDR adds an exception of the denied networks
+func (g *Guard) AllowCIDR(cidr string) error {
+	n, err := parseCIDR(cidr)
+	if err != nil {
+		return err
+	}
+	g.Allow = append(g.Allow, n)
+	return nil
+}
+
+// Check returns an error if the IP is denied
+func (g *Guard) Check(ip net.IP) error {
+	if g == nil {
+		return nil
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	for _, n := range g.Allow {
+		if n.Contains(ip) {
+			return nil
+		}
+	}
+	for _, n := range g.Deny {
+		if n.Contains(ip) {
+			return &ReplyError{
+				Code: ReplyConnectionNotAllowed,
+				Err:  fmt.Errorf("%w : %s", ErrAddressDenied, ip),
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package socks5
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestGuard(t *testing.T) {
+	g := NewGuard()
+	if err := g.AllowCIDR("10.1.0.0/16"); err != nil {
+		t.Fatal(err)
+	}
+	t1 := map[string]bool{
+		"127.0.0.1":        false,
+		"169.254.169.254":  false,
+		"10.0.0.1":         false,
+		"10.1.2.3":         true,
+		"192.168.1.1":      false,
+		"::1":              false,
+		"::ffff:127.0.0.1": false,
+		"fd00::1":          false,
+		"192.0.2.1":        true,
+		"2001:db8::1":      true,
+	}
+	for i, ok := range t1 {
+		err := g.Check(net.ParseIP(i))
+		if (err == nil) != ok {
+			t.Fatalf("%s : %v", i, err)
+		}
+		if err != nil && getReplyCode(err) != ReplyConnectionNotAllowed {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestGuardDial(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+
+	ctx := context.Background()
+	d := &Dialer{Guard: NewGuard()}
+	for _, host := range []string{"127.0.0.1", "localhost"} {
+		_, err := d.DialContext(ctx, "tcp", net.JoinHostPort(host, port))
+		if !errors.Is(err, ErrAddressDenied) {
+			t.Fatal(host, err)
+		}
+	}
+
+	// per user
+	s := NewServerWithAuth("user", "password")
+	s.Dialer = d
+	s.UserDialers = map[string]*Dialer{"admin": {}}
+	s.Authenticate = func(ctx context.Context, auth *Authentication) bool {
+		return true
+	}
+	l2, err := net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l2.Close()
+	go s.Serve(l2)
+
+	c, err := NewClientWithAuth(l2.Addr().String(), "user", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Dial("tcp", l.Addr().String()); !errors.Is(err, ErrReplyFailure) {
+		t.Fatal(err)
+	}
+	c, err = NewClientWithAuth(l2.Addr().String(), "admin", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := c.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+}
@@ -0,0 +1,196 @@
+package socks5
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// ErrReplayed represents a challenge response is replayed or out of date
+var ErrReplayed = errors.New("challenge response replayed")
+
+// DefaultHMACWindow is the accepted clock skew of MethodHMAC responses
+const DefaultHMACWindow = 2 * time.Minute
+
+const (
+	hmacNonceLen       = 32
+	hmacClientNonceLen = 16
+)
+
+// MethodHMAC is a challenge-response method replacing username/password
+// when both peers offer it, no secret crosses the wire:
+//
+//	server: VER NONCE(32)
+//	client: VER ULEN UNAME CNONCE(16) TIME(8) MAC(32)
+//	server: VER STATUS
+//
+// MAC is HMAC-SHA256(secret, NONCE ULEN UNAME CNONCE TIME), TIME is the
+// unix time of the client. The server refuses a CNONCE seen before and a
+// TIME out of DefaultHMACWindow, STATUS is that of username/password.
+
+// sendHMACChallenge sends a new nonce
+func sendHMACChallenge(w io.Writer) ([]byte, error) {
+	msg := make([]byte, 1+hmacNonceLen)
+	msg[0] = Version5
+	if _, err := rand.Read(msg[1:]); err != nil {
+		return nil, err
+	}
+	_, err := w.Write(msg)
+	return msg[1:], err
+}
+
+// hmacResponse is the response of the client, info is the signed part
+type hmacResponse struct {
+	username []byte
+	cnonce   []byte
+	time     time.Time
+	info     []byte
+	mac      []byte
+}
+
+func readHMACResponse(r io.Reader) (*hmacResponse, error) {
+	hdr := []byte{0, 0}
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return nil, err
+	}
+	if hdr[0] != Version5 {
+		return nil, fmt.Errorf("%w : %02x", ErrInvalidVersion, hdr[0])
+	}
+	info := make([]byte, 1+int(hdr[1])+hmacClientNonceLen+8)
+	info[0] = hdr[1]
+	if _, err := io.ReadFull(r, info[1:]); err != nil {
+		return nil, err
+	}
+	mac := make([]byte, sha256.Size)
+	if _, err := io.ReadFull(r, mac); err != nil {
+		return nil, err
+	}
+	n := 1 + int(hdr[1])
+	return &hmacResponse{
+		username: info[1:n],
+		cnonce:   info[n : n+hmacClientNonceLen],
+		time:     time.Unix(int64(binary.BigEndian.Uint64(info[n+hmacClientNonceLen:])), 0),
+		info:     info,
+		mac:      mac,
+	}, nil
+}
+
+func hmacSign(secret, nonce, info []byte) []byte {
+	m := hmac.New(sha256.New, secret)
+	m.Write(nonce)
+	m.Write(info)
+	return m.Sum(nil)
+}
+
+// sendHMACResponse answers the challenge of the server
+func sendHMACResponse(rw io.ReadWriter, username, secret []byte) error {
+	hdr := []byte{0, 0}
+	if _, err := io.ReadFull(rw, hdr[:1]); err != nil {
+		return err
+	}
+	if hdr[0] != Version5 {
+		return fmt.Errorf("%w : %02x", ErrInvalidVersion, hdr[0])
+	}
+	nonce := make([]byte, hmacNonceLen)
+	if _, err := io.ReadFull(rw, nonce); err != nil {
+		return err
+	}
+	if len(username) > 255 {
+		return ErrInvalidAuth
+	}
+	info := append([]byte{byte(len(username))}, username...)
+	cnonce := make([]byte, hmacClientNonceLen)
+	if _, err := rand.Read(cnonce); err != nil {
+		return err
+	}
+	info = append(info, cnonce...)
+	info = append(info, make([]byte, 8)...)
+	binary.BigEndian.PutUint64(info[len(info)-8:], uint64(time.Now().Unix()))
+	msg := append([]byte{Version5}, info...)
+	msg = append(msg, hmacSign(secret, nonce, info)...)
+	_, err := rw.Write(msg)
+	return err
+}
+
+// readHMACAuth challenges the client, it returns the identity
+// and the verification of the response
+func (s *Server) readHMACAuth(ctx context.Context, rw io.ReadWriter) (*Authentication, func() bool, error) {
+	nonce, err := sendHMACChallenge(rw)
+	if err != nil {
+		return nil, nil, err
+	}
+	resp, err := readHMACResponse(rw)
+	if err != nil {
+		return nil, nil, err
+	}
+	auth := &Authentication{Username: resp.username}
+	verify := func() bool {
+		var secret []byte
+		ok := false
+		if s.HMACSecret != nil {
+			secret, ok = s.HMACSecret(ctx, string(auth.Username))
+		}
+		// an unknown user takes as long as a known one
+		valid := hmac.Equal(hmacSign(secret, nonce, resp.info), resp.mac)
+		if !ok || !valid {
+			return false
+		}
+		if err := s.nonces.use(resp.cnonce, resp.time, DefaultHMACWindow); err != nil {
+			s.Metrics.Add("hmac_replayed", 1)
+			s.logf("%v : %s", err, resp.username)
+			return false
+		}
+		return true
+	}
+	return auth, verify, nil
+}
+
+// nonceCache tracks the client nonces within their window
+type nonceCache struct {
+	mu        sync.Mutex
+	seen      map[string]time.Time
+	lastPrune time.Time
+}
+
+// use records the nonce, it fails if the nonce is seen or out of the window
+func (c *nonceCache) use(nonce []byte, t time.Time, window time.Duration) error {
+	now := time.Now()
+	if t.Before(now.Add(-window)) || t.After(now.Add(window)) {
+		return fmt.Errorf("%w : time %s", ErrReplayed, t.Format(time.RFC3339))
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if now.Sub(c.lastPrune) > window {
+		for k, expires := range c.seen {
+			if now.After(expires) {
+				delete(c.seen, k)
+			}
+		}
+		c.lastPrune = now
+	}
+	if _, ok := c.seen[string(nonce)]; ok {
+		return fmt.Errorf("%w : nonce", ErrReplayed)
+	}
+	if c.seen == nil {
+		c.seen = make(map[string]time.Time)
+	}
+	// a nonce older than the window fails the time check
+	c.seen[string(nonce)] = t.Add(window)
+	return nil
+}
+
+func hasMethod(methods []Method, m Method) bool {
+	for _, i := range methods {
+		if i == m {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,99 @@
+package socks5
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestHMACAuth(t *testing.T) {
+	methods := make(chan Method, 1)
+	s := NewServerWithAuth("alice", "password")
+	s.HMACSecret = func(ctx context.Context, username string) ([]byte, bool) {
+		return []byte("secret"), username == "alice"
+	}
+	s.HandleRequest = HandleRequestSkip
+	l, err := net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				event, _ := s.Handshake(context.Background(), conn)
+				methods <- event.Method
+			}()
+		}
+	}()
+	proxy := l.Addr().String()
+
+	dial := func(username, password string, secret []byte) (Method, error) {
+		c, err := NewClientWithAuth(proxy, username, password)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.Secret = secret
+		conn, err := c.Dial("tcp", "192.0.2.1:80")
+		if err == nil {
+			conn.Close()
+		}
+		return <-methods, err
+	}
+	if m, err := dial("alice", "", []byte("secret")); err != nil || m != MethodHMAC {
+		t.Fatal(m, err)
+	}
+	if m, err := dial("alice", "", []byte("wrong")); !errors.Is(err, ErrAuthFailed) || m != MethodHMAC {
+		t.Fatal(m, err)
+	}
+	if m, err := dial("bob", "", []byte("secret")); !errors.Is(err, ErrAuthFailed) || m != MethodHMAC {
+		t.Fatal(m, err)
+	}
+
+	// fallback to username/password
+	if m, err := dial("alice", "password", nil); err != nil || m != MethodUsernamePassword {
+		t.Fatal(m, err)
+	}
+	s.HMACSecret = nil
+	if m, err := dial("alice", "password", []byte("secret")); err != nil || m != MethodUsernamePassword {
+		t.Fatal(m, err)
+	}
+}
+
+func TestHMACReplay(t *testing.T) {
+	nonce := bytes.Repeat([]byte{1}, hmacNonceLen)
+	var resp bytes.Buffer
+	rw := struct {
+		io.Reader
+		io.Writer
+	}{bytes.NewReader(append([]byte{Version5}, nonce...)), &resp}
+	if err := sendHMACResponse(rw, []byte("alice"), []byte("secret")); err != nil {
+		t.Fatal(err)
+	}
+	r, err := readHMACResponse(&resp)
+	if err != nil || string(r.username) != "alice" ||
+		!bytes.Equal(hmacSign([]byte("secret"), nonce, r.info), r.mac) {
+		t.Fatal(r, err)
+	}
+
+	var c nonceCache
+	if err := c.use(r.cnonce, r.time, DefaultHMACWindow); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.use(r.cnonce, r.time, DefaultHMACWindow); !errors.Is(err, ErrReplayed) {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-2 * DefaultHMACWindow)
+	if err := c.use([]byte("fresh"), old, DefaultHMACWindow); !errors.Is(err, ErrReplayed) {
+		t.Fatal(err)
+	}
+}
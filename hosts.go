@@ -0,0 +1,150 @@
+package socks5
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// HostsFile is a list of domains in hosts-file format.
+//
+//	# <ip> <name>...
+//	10.0.0.5 api.example.com www.example.com
+//	0.0.0.0 ads.example.com
+//
+// An override list pins the domains to the IPs,
+// a blocklist refuses the domains with Code and ignores the IPs.
+// Lines of a blocklist may also hold bare domain names.
+type HostsFile struct {
+	Filename string
+	Block    bool
+	Code     ReplyCode
+
+	hits    uint64
+	mu      sync.RWMutex
+	entries map[string][]net.IP
+}
+
+// LoadHosts loads a list pinning domains to IPs
+func LoadHosts(filename string) (*HostsFile, error) {
+	h := &HostsFile{Filename: filename}
+	return h, h.Reload()
+}
+
+// LoadBlocklist loads a list refusing domains with code,
+// code should be ReplyConnectionNotAllowed or ReplyHostUnreachable.
+func LoadBlocklist(filename string, code ReplyCode) (*HostsFile, error) {
+	h := &HostsFile{Filename: filename, Block: true, Code: code}
+	return h, h.Reload()
+}
+
+// Reload reads the file again, the old entries are kept on error
+func (h *HostsFile) Reload() error {
+	f, err := os.Open(h.Filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	entries, err := h.parse(f)
+	if err != nil {
+		return fmt.Errorf("%s : %w", h.Filename, err)
+	}
+	h.mu.Lock()
+	h.entries = entries
+	h.mu.Unlock()
+	return nil
+}
+
+func (h *HostsFile) parse(r io.Reader) (map[string][]net.IP, error) {
+	entries := make(map[string][]net.IP)
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		ip := net.ParseIP(fields[0])
+		switch {
+		case ip != nil:
+			fields = fields[1:]
+		case !h.Block:
+			return nil, fmt.Errorf("line %d : invalid IP %s", n, fields[0])
+		}
+		for _, name := range fields {
+			name = strings.ToLower(strings.TrimSuffix(name, "."))
+			if !h.Block {
+				entries[name] = append(entries[name], ip)
+			} else {
+				entries[name] = nil
+			}
+		}
+	}
+	return entries, scanner.Err()
+}
+
+// Lookup returns the entry of the host and counts a hit
+func (h *HostsFile) Lookup(host string) ([]net.IP, bool) {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	h.mu.RLock()
+	ips, ok := h.entries[host]
+	h.mu.RUnlock()
+	if ok {
+		atomic.AddUint64(&h.hits, 1)
+	}
+	return ips, ok
+}
+
+// Len returns the number of domains
+func (h *HostsFile) Len() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.entries)
+}
+
+// Hits returns the number of lookups found in the list
+func (h *HostsFile) Hits() uint64 {
+	return atomic.LoadUint64(&h.hits)
+}
+
+// HostsResolver consults the lists in order before the Resolver
+type HostsResolver struct {
+	Lists []*HostsFile
+
+	// Resolver resolves the domains in no list,
+	// the system resolver is used if nil.
+	Resolver Resolver
+}
+
+// LookupIP returns the pinned IPs, a ReplyError for blocked domains,
+// or the answer of the Resolver.
+func (r *HostsResolver) LookupIP(ctx context.Context, host string) ([]net.IP, error) {
+	for _, l := range r.Lists {
+		ips, ok := l.Lookup(host)
+		if !ok {
+			continue
+		}
+		if l.Block {
+			code := l.Code
+			if code == ReplySucceed {
+				code = ReplyConnectionNotAllowed
+			}
+			return nil, &ReplyError{Code: code,
+				Err: fmt.Errorf("%w : %s", ErrAddressDenied, host)}
+		}
+		return ips, nil
+	}
+	if r.Resolver == nil {
+		return systemResolver{}.LookupIP(ctx, host)
+	}
+	return r.Resolver.LookupIP(ctx, host)
+}
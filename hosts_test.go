@@ -0,0 +1,84 @@
+package socks5
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHostsResolver(t *testing.T) {
+	dir, err := ioutil.TempDir("", "socks5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	hostsFile := filepath.Join(dir, "hosts")
+	blockFile := filepath.Join(dir, "block")
+	if err := ioutil.WriteFile(hostsFile, []byte(
+		"# staging\n127.0.0.1 staging.test www.staging.test # pinned\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(blockFile, []byte(
+		"0.0.0.0 ads.test\nmalware.test\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	hosts, err := LoadHosts(hostsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := LoadBlocklist(blockFile, ReplyHostUnreachable)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &HostsResolver{Lists: []*HostsFile{block, hosts}}
+	ctx := context.Background()
+
+	ips, err := r.LookupIP(ctx, "WWW.staging.test")
+	if err != nil || len(ips) != 1 || !ips[0].Equal(net.IPv4(127, 0, 0, 1)) {
+		t.Fatal(ips, err)
+	}
+	for _, i := range []string{"ads.test", "malware.test"} {
+		if _, err := r.LookupIP(ctx, i); getReplyCode(err) != ReplyHostUnreachable {
+			t.Fatal(i, err)
+		}
+	}
+	if hosts.Hits() != 1 || block.Hits() != 2 {
+		t.Fatal(hosts.Hits(), block.Hits())
+	}
+
+	// pinned domain is dialed
+	l, err := net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+	ctx = withConnInfo(ctx, nil)
+	getConnInfo(ctx).resolver = r
+	conn, err := (&Dialer{}).DialContext(ctx, "tcp", "staging.test:"+port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	// reload
+	if err := ioutil.WriteFile(blockFile, []byte("other.test\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := block.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := block.Lookup("ads.test"); ok || block.Len() != 1 {
+		t.Fatal("Error")
+	}
+	if err := ioutil.WriteFile(hostsFile, []byte("bad staging.test\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := hosts.Reload(); err == nil || hosts.Len() != 2 {
+		t.Fatal("Error")
+	}
+}
@@ -0,0 +1,176 @@
+package socks5
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrIsolation represents no isolated source address is left
+var ErrIsolation = errors.New("no isolated source address")
+
+// DefaultIsolationTTL is the default value of Isolation.TTL
+const DefaultIsolationTTL = 10 * time.Minute
+
+// Isolation gives each set of credentials its own egress identity, like
+// IsolateSOCKSAuth of Tor. The set is the username, the password and the
+// username parameters, connections of the same set share the identity.
+//
+// The addresses of SourcePool are claimed by one identity at a time,
+// the address of a prefix is derived from the identity. Direct connections
+// are refused if no address is left for the identity, so a SourcePool is
+// required. SOCKS5 upstreams without credentials are authenticated with
+// the identity, so an isolating upstream (Tor) keeps the sets apart too,
+// requests to the other upstreams are refused.
+// Requests without authentication are not isolated.
+type Isolation struct {
+	// TTL keeps an identity after its last connection, DefaultIsolationTTL if zero
+	TTL time.Duration
+
+	mu      sync.Mutex
+	lastID  uint64
+	egress  map[[sha256.Size]byte]*egress
+	sources map[string]*egress
+}
+
+// Egress is an egress identity of Isolation
+type Egress struct {
+	ID       uint64
+	Username string
+	Sources  []net.IP
+	Conns    int
+	LastUsed time.Time
+}
+
+type egress struct {
+	Egress
+	iso *Isolation
+
+	// seed derives the addresses of prefixes and the upstream credentials
+	seed []byte
+}
+
+// acquire returns the identity of the credentials until release
+func (iso *Isolation) acquire(auth *Authentication, m *Metrics) (e *egress, release func()) {
+	key := isolationKey(auth)
+	iso.mu.Lock()
+	defer iso.mu.Unlock()
+	iso.prune(m)
+	e, ok := iso.egress[key]
+	if !ok {
+		if iso.egress == nil {
+			iso.egress = make(map[[sha256.Size]byte]*egress)
+		}
+		iso.lastID++
+		e = &egress{Egress: Egress{ID: iso.lastID, Username: string(auth.Username)},
+			iso: iso, seed: make([]byte, 32)}
+		rand.Read(e.seed)
+		iso.egress[key] = e
+		m.Add("isolation_egress", 1)
+	}
+	e.Conns++
+	e.LastUsed = time.Now()
+	return e, func() {
+		iso.mu.Lock()
+		e.Conns--
+		e.LastUsed = time.Now()
+		iso.mu.Unlock()
+	}
+}
+
+func isolationKey(auth *Authentication) [sha256.Size]byte {
+	h := sha256.New()
+	h.Write(auth.Username)
+	h.Write([]byte{0})
+	h.Write(auth.Password)
+	keys := make([]string, 0, len(auth.Params))
+	for k := range auth.Params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(h, "\x00%s=%s", k, auth.Params[k])
+	}
+	var key [sha256.Size]byte
+	h.Sum(key[:0])
+	return key
+}
+
+// prune drops the idle identities after TTL and frees their addresses
+func (iso *Isolation) prune(m *Metrics) {
+	ttl := iso.TTL
+	if ttl == 0 {
+		ttl = DefaultIsolationTTL
+	}
+	now := time.Now()
+	for k, e := range iso.egress {
+		if e.Conns > 0 || now.Sub(e.LastUsed) < ttl {
+			continue
+		}
+		for _, ip := range e.Sources {
+			delete(iso.sources, ip.String())
+		}
+		delete(iso.egress, k)
+		m.Add("isolation_expired", 1)
+	}
+}
+
+// source returns the address of the identity among the candidates,
+// nil if all are claimed by others
+func (e *egress) source(candidates []sourceCandidate) (net.IP, bool) {
+	iso := e.iso
+	iso.mu.Lock()
+	defer iso.mu.Unlock()
+	for _, ip := range e.Sources {
+		for _, c := range candidates {
+			if c.net == nil && c.ip.Equal(ip) {
+				return ip, false
+			}
+			if c.net != nil && c.net.Contains(ip) {
+				return ip, true
+			}
+		}
+	}
+	for _, c := range candidates {
+		ip, free := c.ip, false
+		if c.net != nil {
+			ip, free = randomIPInNet(c.net, e.seed), true
+		}
+		if _, ok := iso.sources[ip.String()]; ok {
+			continue
+		}
+		if iso.sources == nil {
+			iso.sources = make(map[string]*egress)
+		}
+		iso.sources[ip.String()] = e
+		e.Sources = append(e.Sources, ip)
+		return ip, free
+	}
+	return nil, false
+}
+
+// Egresses returns the identities, ordered by ID
+func (iso *Isolation) Egresses() []Egress {
+	iso.mu.Lock()
+	defer iso.mu.Unlock()
+	list := make([]Egress, 0, len(iso.egress))
+	for _, e := range iso.egress {
+		c := e.Egress
+		c.Sources = append([]net.IP(nil), e.Sources...)
+		list = append(list, c)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+// upstreamAuth is the credentials of the identity for SOCKS5 upstreams
+func (e *egress) upstreamAuth() (username, password string) {
+	sum := sha256.Sum256(append([]byte("upstream"), e.seed...))
+	return "isolation", hex.EncodeToString(sum[:16])
+}
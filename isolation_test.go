@@ -0,0 +1,128 @@
+package socks5
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestIsolation(t *testing.T) {
+	iso := &Isolation{}
+	m := NewMetrics()
+	auth := func(username, password string) *Authentication {
+		a, _ := newAuth(username, password)
+		return a
+	}
+	a1, release1 := iso.acquire(auth("alice", "one"), m)
+	a2, release2 := iso.acquire(auth("alice", "one"), m)
+	b, releaseB := iso.acquire(auth("alice", "two"), m)
+	if a1 != a2 || a1 == b {
+		t.Fatal(a1.ID, a2.ID, b.ID)
+	}
+	session := auth("alice", "one")
+	session.Params = map[string]string{ParamSession: "abc"}
+	if c, release := iso.acquire(session, m); c == a1 {
+		t.Fatal(c.ID)
+	} else {
+		release()
+	}
+
+	// addresses are never shared
+	candidates := []sourceCandidate{{ip: net.ParseIP("192.0.2.1")}, {ip: net.ParseIP("192.0.2.2")}}
+	ipA, _ := a1.source(candidates)
+	ipB, _ := b.source(candidates)
+	if ipA == nil || ipB == nil || ipA.Equal(ipB) {
+		t.Fatal(ipA, ipB)
+	}
+	if ip, _ := a2.source(candidates[1:]); ip != nil {
+		t.Fatal(ip)
+	}
+	if ip, _ := a2.source(candidates); !ip.Equal(ipA) {
+		t.Fatal(ip)
+	}
+	_, prefix, _ := net.ParseCIDR("2001:db8::/64")
+	v6 := []sourceCandidate{{net: prefix}}
+	ip6, free := a1.source(v6)
+	if ip, _ := a2.source(v6); !free || !prefix.Contains(ip6) || !ip.Equal(ip6) {
+		t.Fatal(ip6, ip)
+	}
+	if ip, _ := b.source(v6); ip.Equal(ip6) {
+		t.Fatal(ip)
+	}
+
+	list := iso.Egresses()
+	if len(list) != 3 || list[0].Conns != 2 || len(list[0].Sources) != 2 {
+		t.Fatal(list)
+	}
+
+	// expiry after the last connection
+	iso.TTL = time.Nanosecond
+	release1()
+	release2()
+	releaseB()
+	time.Sleep(time.Millisecond)
+	c, release := iso.acquire(auth("carol", "three"), m)
+	defer release()
+	if ip, _ := c.source(candidates); ip == nil || len(iso.Egresses()) != 1 {
+		t.Fatal(ip, iso.Egresses())
+	}
+	if m.Get("isolation_egress") != 4 || m.Get("isolation_expired") != 3 {
+		t.Fatal(m.String())
+	}
+}
+
+func TestIsolationDial(t *testing.T) {
+	echo := testEchoServer(t)
+	defer echo.Close()
+	iso := &Isolation{}
+	d := &Dialer{Source: &SourcePool{IPs: []net.IP{net.ParseIP("127.0.0.1")}}}
+	dial := func(username string) error {
+		ctx := withConnInfo(context.Background(), nil)
+		a, _ := newAuth(username, "password")
+		var release func()
+		getConnInfo(ctx).egress, release = iso.acquire(a, nil)
+		defer release()
+		conn, err := d.DialContext(ctx, "tcp", echo.Addr().String())
+		if err == nil {
+			conn.Close()
+		}
+		return err
+	}
+	if err := dial("alice"); err != nil {
+		t.Fatal(err)
+	}
+	if err := dial("alice"); err != nil {
+		t.Fatal(err)
+	}
+	var re *ReplyError
+	if err := dial("bob"); !errors.Is(err, ErrIsolation) || !errors.As(err, &re) ||
+		re.Code != ReplyConnectionNotAllowed {
+		t.Fatal(err)
+	}
+}
+
+func TestIsolationUpstream(t *testing.T) {
+	echo := testEchoServer(t)
+	defer echo.Close()
+	ctx := withConnInfo(context.Background(), nil)
+	a, _ := newAuth("alice", "password")
+	var release func()
+	getConnInfo(ctx).egress, release = (&Isolation{}).acquire(a, nil)
+	defer release()
+
+	dst, _ := NewAddress(echo.Addr().String())
+	req := &Request{Cmd: CmdConnect, Dst: *dst}
+	for _, u := range []*Upstream{
+		{Name: "http", Type: "http", Address: echo.Addr().String()},
+		{Name: "socks5", Type: "socks5", Address: echo.Addr().String(), Username: "user"},
+	} {
+		reply, target, err := u.HandleRequest(ctx, a, req)
+		var re *ReplyError
+		if target != nil || reply == nil || reply.Code != ReplyConnectionNotAllowed ||
+			!errors.Is(err, ErrIsolation) || !errors.As(err, &re) {
+			t.Fatal(u.Name, reply, err)
+		}
+	}
+}
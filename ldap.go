@@ -0,0 +1,551 @@
+package socks5
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrLDAP represents an LDAP operation failed
+var ErrLDAP = errors.New("ldap operation failed")
+
+// Default values of LDAP
+const (
+	DefaultLDAPTimeout  = 5 * time.Second
+	DefaultLDAPPoolSize = 4
+)
+
+// LDAP protocol operations, results and filters
+const (
+	ldapBindRequest      = 0x60
+	ldapBindResponse     = 0x61
+	ldapUnbindRequest    = 0x42
+	ldapSearchRequest    = 0x63
+	ldapSearchEntry      = 0x64
+	ldapSearchDone       = 0x65
+	ldapSearchReference  = 0x73
+	ldapExtendedRequest  = 0x77
+	ldapExtendedResponse = 0x78
+
+	ldapAuthSimple   = 0x80
+	ldapExtendedName = 0x80
+
+	ldapFilterAnd      = 0xa0
+	ldapFilterOr       = 0xa1
+	ldapFilterNot      = 0xa2
+	ldapFilterEquality = 0xa3
+	ldapFilterPresent  = 0x87
+
+	ldapScopeSubtree = 2
+
+	ldapSuccess            = 0
+	ldapInvalidCredentials = 49
+
+	ldapStartTLSOID = "1.3.6.1.4.1.1466.20037"
+)
+
+// LDAP authenticates with simple binds to a directory.
+//
+// The DN of the user is the UserDN template, or it is found by
+// a search of UserFilter under BaseDN bound as SearchDN.
+// The groups are the GroupName attributes of the entries found by
+// GroupFilter under GroupBaseDN, they are set as the "group" attribute.
+//
+//	&LDAP{
+//		Address:     "ldap.example.com:389",
+//		StartTLS:    true,
+//		UserDN:      "uid=%s,ou=people,dc=example,dc=com",
+//		GroupBaseDN: "ou=groups,dc=example,dc=com",
+//		GroupFilter: "(member=%s)",
+//	}
+type LDAP struct {
+	Address string
+
+	// TLS is LDAPS, or the config of StartTLS
+	TLS      *tls.Config
+	StartTLS bool
+
+	// UserDN is a template of the username, e.g. "uid=%s,dc=example,dc=com"
+	UserDN string
+
+	// Search of the user if UserDN is empty,
+	// UserFilter is a template of the username, e.g. "(uid=%s)"
+	SearchDN       string
+	SearchPassword string
+	BaseDN         string
+	UserFilter     string
+	Attributes     []string // of the user entry, set as attributes
+
+	// Search of the groups, GroupFilter is a template of the user DN
+	GroupBaseDN string
+	GroupFilter string
+	GroupName   string // "cn" if empty
+
+	Timeout  time.Duration
+	PoolSize int
+
+	// CacheTTL caches the successful binds, zero disables it
+	CacheTTL time.Duration
+
+	once  sync.Once
+	pool  chan *ldapConn
+	cache authCache
+}
+
+// Authenticate is a Server.Authenticate
+func (l *LDAP) Authenticate(ctx context.Context, auth *Authentication) bool {
+	if auth == nil {
+		return false
+	}
+	attrs, err := l.Check(ctx, string(auth.Username), string(auth.Password))
+	if err != nil {
+		return false
+	}
+	auth.Attributes = attrs
+	return true
+}
+
+// Check binds as the user, it returns the attributes of the user
+// or ErrAuthFailed if the credentials are invalid.
+func (l *LDAP) Check(ctx context.Context, username, password string) (map[string][]string, error) {
+	// an empty password is an unauthenticated bind
+	if username == "" || password == "" {
+		return nil, ErrAuthFailed
+	}
+	if attrs, ok, found := l.cache.get(username, password); found && ok {
+		return attrs, nil
+	}
+	timeout := l.Timeout
+	if timeout <= 0 {
+		timeout = DefaultLDAPTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	attrs, err := l.check(ctx, username, password, true)
+	if err != nil && !errors.Is(err, ErrAuthFailed) && ctx.Err() == nil {
+		// the pooled connection may be closed by the server
+		attrs, err = l.check(ctx, username, password, false)
+	}
+	if err == nil {
+		l.cache.put(username, password, attrs, true, l.CacheTTL)
+	}
+	return attrs, err
+}
+
+func (l *LDAP) check(ctx context.Context, username, password string, pooled bool) (
+	map[string][]string, error) {
+	conn, err := l.get(ctx, pooled)
+	if err != nil {
+		return nil, err
+	}
+	ok := false
+	defer func() { l.put(conn, ok) }()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	attrs := make(map[string][]string)
+	dn := ""
+	if l.UserDN != "" {
+		dn = strings.Replace(l.UserDN, "%s", escapeDN(username), -1)
+	} else {
+		if err := conn.bind(l.SearchDN, l.SearchPassword); err != nil {
+			return nil, err
+		}
+		filter := strings.Replace(l.UserFilter, "%s", escapeFilter(username), -1)
+		entries, err := conn.search(l.BaseDN, filter, l.Attributes)
+		if err != nil {
+			return nil, err
+		}
+		if len(entries) != 1 {
+			ok = true
+			return nil, ErrAuthFailed
+		}
+		dn = entries[0].dn
+		for k, v := range entries[0].attrs {
+			attrs[k] = v
+		}
+	}
+
+	if err := conn.bind(dn, password); err != nil {
+		ok = errors.Is(err, ErrAuthFailed)
+		return nil, err
+	}
+	if l.GroupFilter != "" {
+		name := l.GroupName
+		if name == "" {
+			name = "cn"
+		}
+		filter := strings.Replace(l.GroupFilter, "%s", escapeFilter(dn), -1)
+		entries, err := conn.search(l.GroupBaseDN, filter, []string{name})
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			for k, v := range e.attrs {
+				if strings.EqualFold(k, name) {
+					attrs[AttributeGroup] = append(attrs[AttributeGroup], v...)
+				}
+			}
+		}
+	}
+	ok = true
+	return attrs, nil
+}
+
+// get takes a pooled connection or dials a new one
+func (l *LDAP) get(ctx context.Context, pooled bool) (*ldapConn, error) {
+	l.once.Do(func() {
+		size := l.PoolSize
+		if size <= 0 {
+			size = DefaultLDAPPoolSize
+		}
+		l.pool = make(chan *ldapConn, size)
+	})
+	if pooled {
+		select {
+		case c := <-l.pool:
+			return c, nil
+		default:
+		}
+	}
+	return l.dial(ctx)
+}
+
+// put returns a healthy connection to the pool
+func (l *LDAP) put(c *ldapConn, ok bool) {
+	if ok {
+		c.SetDeadline(time.Time{})
+		select {
+		case l.pool <- c:
+			return
+		default:
+		}
+	}
+	c.close()
+}
+
+func (l *LDAP) dial(ctx context.Context) (*ldapConn, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", l.Address)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	tlsConfig := l.TLS
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{}
+	}
+	if tlsConfig.ServerName == "" {
+		host, _, _ := net.SplitHostPort(l.Address)
+		tlsConfig = tlsConfig.Clone()
+		tlsConfig.ServerName = host
+	}
+	if l.TLS != nil && !l.StartTLS {
+		conn = tls.Client(conn, tlsConfig)
+	}
+	c := newLDAPConn(conn)
+	if l.StartTLS {
+		if err := c.startTLS(tlsConfig); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// ldapConn is a connection of LDAP operations one at a time
+type ldapConn struct {
+	net.Conn
+	r  *bufio.Reader
+	id int
+}
+
+type ldapEntry struct {
+	dn    string
+	attrs map[string][]string
+}
+
+func newLDAPConn(conn net.Conn) *ldapConn {
+	return &ldapConn{Conn: conn, r: bufio.NewReader(conn)}
+}
+
+func (c *ldapConn) close() {
+	c.Write(ber(berSequence, berInt(berInteger, c.id+1), ber(ldapUnbindRequest)))
+	c.Close()
+}
+
+// send sends a request, it returns the message ID
+func (c *ldapConn) send(op []byte) (int, error) {
+	c.id++
+	_, err := c.Write(ber(berSequence, berInt(berInteger, c.id), op))
+	return c.id, err
+}
+
+// receive reads the protocol operation of the next response of the message
+func (c *ldapConn) receive(id int) (berElement, error) {
+	for {
+		msg, err := readBER(c.r)
+		if err != nil {
+			return berElement{}, err
+		}
+		fields, err := msg.children()
+		if err != nil {
+			return berElement{}, err
+		}
+		if msg.tag != berSequence || len(fields) < 2 {
+			return berElement{}, fmt.Errorf("%w : invalid message", ErrLDAP)
+		}
+		if fields[0].int() == id {
+			return fields[1], nil
+		}
+	}
+}
+
+// result checks the LDAPResult of the response
+func ldapResult(op berElement, tag byte) error {
+	if op.tag != tag {
+		return fmt.Errorf("%w : unexpected response %02x", ErrLDAP, op.tag)
+	}
+	fields, err := op.children()
+	if err != nil {
+		return err
+	}
+	if len(fields) < 3 {
+		return fmt.Errorf("%w : invalid result", ErrLDAP)
+	}
+	switch code := fields[0].int(); code {
+	case ldapSuccess:
+		return nil
+	case ldapInvalidCredentials:
+		return ErrAuthFailed
+	default:
+		return fmt.Errorf("%w : result %d %s", ErrLDAP, code, fields[2].str())
+	}
+}
+
+func (c *ldapConn) bind(dn, password string) error {
+	id, err := c.send(ber(ldapBindRequest,
+		berInt(berInteger, 3),
+		berString(berOctetString, dn),
+		berString(ldapAuthSimple, password)))
+	if err != nil {
+		return err
+	}
+	op, err := c.receive(id)
+	if err != nil {
+		return err
+	}
+	return ldapResult(op, ldapBindResponse)
+}
+
+func (c *ldapConn) search(base, filter string, attrs []string) ([]ldapEntry, error) {
+	f, err := parseLDAPFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+	var list [][]byte
+	for _, a := range attrs {
+		list = append(list, berString(berOctetString, a))
+	}
+	id, err := c.send(ber(ldapSearchRequest,
+		berString(berOctetString, base),
+		berInt(berEnumerated, ldapScopeSubtree),
+		berInt(berEnumerated, 0), // never deref aliases
+		berInt(berInteger, 0),    // size limit
+		berInt(berInteger, 0),    // time limit
+		berBool(false),
+		f,
+		ber(berSequence, list...)))
+	if err != nil {
+		return nil, err
+	}
+	var entries []ldapEntry
+	for {
+		op, err := c.receive(id)
+		if err != nil {
+			return nil, err
+		}
+		switch op.tag {
+		case ldapSearchEntry:
+			e, err := parseLDAPEntry(op)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, e)
+		case ldapSearchReference:
+		default:
+			return entries, ldapResult(op, ldapSearchDone)
+		}
+	}
+}
+
+func parseLDAPEntry(op berElement) (ldapEntry, error) {
+	fields, err := op.children()
+	if err != nil {
+		return ldapEntry{}, err
+	}
+	if len(fields) < 2 {
+		return ldapEntry{}, fmt.Errorf("%w : invalid entry", ErrLDAP)
+	}
+	e := ldapEntry{dn: fields[0].str(), attrs: make(map[string][]string)}
+	attrs, err := fields[1].children()
+	if err != nil {
+		return ldapEntry{}, err
+	}
+	for _, a := range attrs {
+		kv, err := a.children()
+		if err != nil || len(kv) != 2 {
+			return ldapEntry{}, fmt.Errorf("%w : invalid attribute", ErrLDAP)
+		}
+		values, err := kv[1].children()
+		if err != nil {
+			return ldapEntry{}, err
+		}
+		for _, v := range values {
+			e.attrs[kv[0].str()] = append(e.attrs[kv[0].str()], v.str())
+		}
+	}
+	return e, nil
+}
+
+func (c *ldapConn) startTLS(config *tls.Config) error {
+	id, err := c.send(ber(ldapExtendedRequest, berString(ldapExtendedName, ldapStartTLSOID)))
+	if err != nil {
+		return err
+	}
+	op, err := c.receive(id)
+	if err != nil {
+		return err
+	}
+	if err := ldapResult(op, ldapExtendedResponse); err != nil {
+		return err
+	}
+	conn := tls.Client(c.Conn, config)
+	if err := conn.Handshake(); err != nil {
+		return err
+	}
+	c.Conn, c.r = conn, bufio.NewReader(conn)
+	return nil
+}
+
+// parseLDAPFilter encodes the filters of and, or, not, equality and presence
+func parseLDAPFilter(s string) ([]byte, error) {
+	b, rest, err := parseFilterItem(strings.TrimSpace(s))
+	if err != nil {
+		return nil, err
+	}
+	if rest != "" {
+		return nil, fmt.Errorf("%w : invalid filter %s", ErrLDAP, s)
+	}
+	return b, nil
+}
+
+func parseFilterItem(s string) ([]byte, string, error) {
+	if len(s) < 2 || s[0] != '(' {
+		return nil, "", fmt.Errorf("%w : invalid filter %s", ErrLDAP, s)
+	}
+	s = s[1:]
+	switch s[0] {
+	case '&', '|', '!':
+		tag := map[byte]byte{'&': ldapFilterAnd, '|': ldapFilterOr, '!': ldapFilterNot}[s[0]]
+		s = s[1:]
+		var list [][]byte
+		for len(s) > 0 && s[0] == '(' {
+			b, rest, err := parseFilterItem(s)
+			if err != nil {
+				return nil, "", err
+			}
+			list, s = append(list, b), rest
+		}
+		if len(s) == 0 || s[0] != ')' || len(list) == 0 || tag == ldapFilterNot && len(list) != 1 {
+			return nil, "", fmt.Errorf("%w : invalid filter", ErrLDAP)
+		}
+		return ber(tag, list...), s[1:], nil
+	}
+	end := strings.IndexByte(s, ')')
+	if end < 0 {
+		return nil, "", fmt.Errorf("%w : invalid filter", ErrLDAP)
+	}
+	item, rest := s[:end], s[end+1:]
+	i := strings.IndexByte(item, '=')
+	if i <= 0 {
+		return nil, "", fmt.Errorf("%w : invalid filter %s", ErrLDAP, item)
+	}
+	attr, value := item[:i], item[i+1:]
+	if value == "*" {
+		return berString(ldapFilterPresent, attr), rest, nil
+	}
+	if strings.ContainsAny(attr, "~<>:") || strings.Contains(value, "*") {
+		return nil, "", fmt.Errorf("%w : unsupported filter %s", ErrLDAP, item)
+	}
+	v, err := unescapeFilter(value)
+	if err != nil {
+		return nil, "", err
+	}
+	return ber(ldapFilterEquality, berString(berOctetString, attr), berString(berOctetString, v)), rest, nil
+}
+
+// escapeFilter escapes a value of a filter as RFC 4515
+func escapeFilter(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '*', '(', ')', '\\', 0:
+			fmt.Fprintf(&b, "\\%02x", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+func unescapeFilter(s string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			b.WriteByte(s[i])
+			continue
+		}
+		if i+2 >= len(s) {
+			return "", fmt.Errorf("%w : invalid escape %s", ErrLDAP, s)
+		}
+		c, err := hex.DecodeString(s[i+1 : i+3])
+		if err != nil {
+			return "", fmt.Errorf("%w : invalid escape %s", ErrLDAP, s)
+		}
+		b.Write(c)
+		i += 2
+	}
+	return b.String(), nil
+}
+
+// escapeDN escapes an attribute value of a DN as RFC 4514
+func escapeDN(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case strings.IndexByte(`,+"\<>;=`, c) >= 0,
+			c == '#' && i == 0,
+			c == ' ' && (i == 0 || i == len(s)-1):
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c == 0:
+			b.WriteString(`\00`)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
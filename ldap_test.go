@@ -0,0 +1,254 @@
+package socks5
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testCertificate creates a self-signed certificate of 127.0.0.1
+func testCertificate(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "127.0.0.1"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: cert}, pool
+}
+
+type testLDAPEntry struct {
+	password string
+	attrs    map[string][]string
+}
+
+// testLDAPServer serves a directory, it counts the connections and binds
+type testLDAPServer struct {
+	net.Listener
+	tls     *tls.Config
+	entries map[string]testLDAPEntry
+	conns   int32
+	binds   int32
+}
+
+func (s *testLDAPServer) serve() {
+	for {
+		conn, err := s.Accept()
+		if err != nil {
+			return
+		}
+		atomic.AddInt32(&s.conns, 1)
+		go s.serveConn(conn)
+	}
+}
+
+func (s *testLDAPServer) serveConn(conn net.Conn) {
+	defer func() { conn.Close() }()
+	r := bufio.NewReader(conn)
+	reply := func(id int, ops ...[]byte) {
+		for _, op := range ops {
+			conn.Write(ber(berSequence, berInt(berInteger, id), op))
+		}
+	}
+	result := func(tag byte, code int) []byte {
+		return ber(tag, berInt(berEnumerated, code),
+			berString(berOctetString, ""), berString(berOctetString, ""))
+	}
+	for {
+		msg, err := readBER(r)
+		if err != nil {
+			return
+		}
+		fields, err := msg.children()
+		if err != nil || len(fields) < 2 {
+			return
+		}
+		id, op := fields[0].int(), fields[1]
+		args, _ := op.children()
+		switch op.tag {
+		case ldapBindRequest:
+			atomic.AddInt32(&s.binds, 1)
+			dn, password := args[1].str(), args[2].str()
+			code := ldapInvalidCredentials
+			if e, ok := s.entries[dn]; password == "" || ok && e.password == password {
+				code = ldapSuccess
+			}
+			reply(id, result(ldapBindResponse, code))
+		case ldapSearchRequest:
+			base := args[0].str()
+			var ops [][]byte
+			for dn, e := range s.entries {
+				if !strings.HasSuffix(dn, base) || !testLDAPMatch(args[6], e.attrs) {
+					continue
+				}
+				var attrs [][]byte
+				for k, v := range e.attrs {
+					var values [][]byte
+					for _, i := range v {
+						values = append(values, berString(berOctetString, i))
+					}
+					attrs = append(attrs, ber(berSequence,
+						berString(berOctetString, k), ber(berSet, values...)))
+				}
+				ops = append(ops, ber(ldapSearchEntry,
+					berString(berOctetString, dn), ber(berSequence, attrs...)))
+			}
+			reply(id, append(ops, result(ldapSearchDone, ldapSuccess))...)
+		case ldapExtendedRequest:
+			reply(id, result(ldapExtendedResponse, ldapSuccess))
+			tc := tls.Server(conn, s.tls)
+			conn, r = tc, bufio.NewReader(tc)
+		default:
+			return
+		}
+	}
+}
+
+func testLDAPMatch(f berElement, attrs map[string][]string) bool {
+	list, _ := f.children()
+	switch f.tag {
+	case ldapFilterAnd, ldapFilterOr:
+		for _, c := range list {
+			if testLDAPMatch(c, attrs) == (f.tag == ldapFilterOr) {
+				return f.tag == ldapFilterOr
+			}
+		}
+		return f.tag == ldapFilterAnd
+	case ldapFilterNot:
+		return !testLDAPMatch(list[0], attrs)
+	case ldapFilterPresent:
+		return len(attrs[f.str()]) > 0
+	case ldapFilterEquality:
+		for _, v := range attrs[list[0].str()] {
+			if strings.EqualFold(v, list[1].str()) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func TestLDAP(t *testing.T) {
+	cert, pool := testCertificate(t)
+	l, err := net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice := "uid=alice,ou=people,dc=example,dc=com"
+	s := &testLDAPServer{
+		Listener: l,
+		tls:      &tls.Config{Certificates: []tls.Certificate{cert}},
+		entries: map[string]testLDAPEntry{
+			"cn=search,dc=example,dc=com": {password: "search"},
+			alice: {password: "secret", attrs: map[string][]string{
+				"uid": {"alice"}, "mail": {"alice@example.com"}}},
+			"cn=staff,ou=groups,dc=example,dc=com": {attrs: map[string][]string{
+				"cn": {"staff"}, "member": {alice}}},
+			"cn=dev,ou=groups,dc=example,dc=com": {attrs: map[string][]string{
+				"cn": {"dev"}, "member": {"uid=bob,ou=people,dc=example,dc=com"}}},
+		},
+	}
+	defer s.Close()
+	go s.serve()
+
+	ctx := context.Background()
+	d := &LDAP{
+		Address:     l.Addr().String(),
+		TLS:         &tls.Config{RootCAs: pool},
+		StartTLS:    true,
+		UserDN:      "uid=%s,ou=people,dc=example,dc=com",
+		GroupBaseDN: "ou=groups,dc=example,dc=com",
+		GroupFilter: "(&(cn=*)(member=%s))",
+	}
+	auth := &Authentication{Username: []byte("alice"), Password: []byte("secret")}
+	if !d.Authenticate(ctx, auth) || !MatchGroup("staff")(ctx, auth, nil) ||
+		len(auth.Attributes[AttributeGroup]) != 1 {
+		t.Fatal(auth.Attributes)
+	}
+	for _, c := range [][2]string{{"alice", "wrong"}, {"alice", ""}, {"alice,ou=x", "secret"}} {
+		if _, err := d.Check(ctx, c[0], c[1]); !errors.Is(err, ErrAuthFailed) {
+			t.Fatal(c, err)
+		}
+	}
+	if n := atomic.LoadInt32(&s.conns); n != 1 {
+		t.Fatal("pool", n)
+	}
+
+	// search of the user and cache
+	d = &LDAP{
+		Address:        l.Addr().String(),
+		SearchDN:       "cn=search,dc=example,dc=com",
+		SearchPassword: "search",
+		BaseDN:         "dc=example,dc=com",
+		UserFilter:     "(uid=%s)",
+		Attributes:     []string{"mail"},
+		CacheTTL:       time.Minute,
+	}
+	for i := 0; i < 2; i++ {
+		attrs, err := d.Check(ctx, "alice", "secret")
+		if err != nil || attrs["mail"][0] != "alice@example.com" {
+			t.Fatal(attrs, err)
+		}
+	}
+	if n := atomic.LoadInt32(&s.binds); n != 3+2 {
+		t.Fatal("cache", n)
+	}
+	if _, err := d.Check(ctx, "*", "secret"); !errors.Is(err, ErrAuthFailed) {
+		t.Fatal(err)
+	}
+}
+
+func TestLDAPFilter(t *testing.T) {
+	t1 := []string{
+		"(uid=alice)",
+		"(&(objectClass=person)(|(uid=a\\2ab)(mail=*))(!(cn=x)))",
+	}
+	for _, f := range t1 {
+		if _, err := parseLDAPFilter(f); err != nil {
+			t.Fatal(f, err)
+		}
+	}
+	t2 := []string{"uid=alice", "(uid=a*)", "(&)", "(!(a=1)(b=2))", "(uid=\\zz)", "(uid=a"}
+	for _, f := range t2 {
+		if _, err := parseLDAPFilter(f); err == nil {
+			t.Fatal(f)
+		}
+	}
+	if s := escapeFilter("a*(b)\\"); s != "a\\2a\\28b\\29\\5c" {
+		t.Fatal(s)
+	}
+	if s := escapeDN(" a,b=c "); s != "\\ a\\,b\\=c\\ " {
+		t.Fatal(s)
+	}
+}
@@ -0,0 +1,71 @@
+package socks5
+
+import (
+	"errors"
+	"strings"
+)
+
+// ErrConnLimit represents a connection limit is reached
+var ErrConnLimit = errors.New("connection limit reached")
+
+// acquireSlot takes a slot of the kind and key,
+// it fails if max slots are taken, max <= 0 is unlimited.
+func (s *Server) acquireSlot(kind, key string, max int) (release func(), ok bool) {
+	if max <= 0 {
+		return func() {}, true
+	}
+	k := kind + "|" + key
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.slots == nil {
+		s.slots = make(map[string]int)
+	}
+	if s.slots[k] >= max {
+		s.Metrics.Add("limit_"+kind, 1)
+		return nil, false
+	}
+	s.slots[k]++
+	return func() {
+		s.mu.Lock()
+		if s.slots[k]--; s.slots[k] <= 0 {
+			delete(s.slots, k)
+		}
+		s.mu.Unlock()
+	}, true
+}
+
+// acquireConn takes the slots of a new client connection
+func (s *Server) acquireConn(info *connInfo) bool {
+	release, ok := s.acquireSlot("conns", "", s.MaxConns)
+	if !ok {
+		return false
+	}
+	info.release = append(info.release, release)
+	if ip := info.clientIP(); ip != nil {
+		release, ok = s.acquireSlot("conns_per_ip", ip.String(), s.MaxConnsPerIP)
+		if !ok {
+			return false
+		}
+		info.release = append(info.release, release)
+	}
+	return true
+}
+
+// acquireRequest takes the slots of the user and the destination host
+func (s *Server) acquireRequest(info *connInfo, event *Event) bool {
+	if event.Auth != nil {
+		release, ok := s.acquireSlot("conns_per_user",
+			string(event.Auth.Username), s.MaxConnsPerUser)
+		if !ok {
+			return false
+		}
+		info.release = append(info.release, release)
+	}
+	release, ok := s.acquireSlot("conns_per_host",
+		strings.ToLower(event.Req.Dst.Host()), s.MaxConnsPerHost)
+	if !ok {
+		return false
+	}
+	info.release = append(info.release, release)
+	return true
+}
@@ -0,0 +1,153 @@
+package socks5
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestConnLimits(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	target := l.Addr().String()
+
+	s := NewServerWithAuth("user", "password")
+	s.Metrics = NewMetrics()
+	s.MaxConnsPerUser = 1
+	l2, err := net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l2.Close()
+	go s.Serve(l2)
+
+	c, err := NewClientWithAuth(l2.Addr().String(), "user", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := c.Dial("tcp", target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Dial("tcp", target); !errors.Is(err, ErrReplyFailure) {
+		t.Fatal(err)
+	}
+	if s.Metrics.Get("limit_conns_per_user") != 1 {
+		t.Fatal(s.Metrics.String())
+	}
+
+	// the slot is free once the relay ends
+	conn.Close()
+	for i := 0; ; i++ {
+		conn, err = c.Dial("tcp", target)
+		if err == nil {
+			conn.Close()
+			break
+		}
+		if i == 20 {
+			t.Fatal(err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	// over MaxConns is closed before the greeting
+	s2 := NewServer()
+	s2.Metrics = NewMetrics()
+	s2.MaxConns = 1
+	l3, err := net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l3.Close()
+	go s2.Serve(l3)
+
+	c2, err := NewClient(l3.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err = c2.Dial("tcp", target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := c2.Dial("tcp", target); err == nil {
+		t.Fatal("Error")
+	}
+	if s2.Metrics.Get("limit_conns") != 1 {
+		t.Fatal(s2.Metrics.String())
+	}
+}
+
+func TestHandshakeTimeout(t *testing.T) {
+	s := NewHandShaker()
+	s.MaxHandshakes = 1
+	s.HandshakeTimeout = 100 * time.Millisecond
+	l, err := net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go s.Serve(l)
+
+	// an idle client is closed and frees its slot
+	idle, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idle.Close()
+	idle.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := idle.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatal(err)
+	}
+
+	c, err := NewClient(l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := c.Dial("tcp", "192.0.2.1:80")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	// a stalled dial is canceled
+	s2 := NewServer()
+	s2.HandshakeTimeout = 100 * time.Millisecond
+	s2.HandleRequest = func(ctx context.Context, auth *Authentication, req *Request) (
+		*Reply, io.ReadWriteCloser, error) {
+		<-ctx.Done()
+		return nil, nil, ctx.Err()
+	}
+	l2, err := net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l2.Close()
+	go s2.Serve(l2)
+	c2, err := NewClient(l2.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if _, err := c2.Dial("tcp", "192.0.2.1:80"); err == nil || time.Since(start) > time.Second {
+		t.Fatal(err, time.Since(start))
+	}
+}
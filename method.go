@@ -13,6 +13,9 @@ type Method byte
 const (
 	MethodNotRequired      Method = 0x00
 	MethodUsernamePassword Method = 0x02
+	MethodHMAC             Method = 0x80
+	MethodAEAD             Method = 0x81
+	MethodDeflate          Method = 0x82
 	MethodNoAcceptable     Method = 0xff
 )
 
@@ -0,0 +1,69 @@
+package socks5
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+)
+
+// Metrics is a set of named counters.
+// It implements expvar.Var, so it can be published with expvar.Publish.
+// A nil *Metrics discards everything.
+type Metrics struct {
+	mu       sync.Mutex
+	counters map[string]int64
+}
+
+// NewMetrics creates a Metrics
+func NewMetrics() *Metrics {
+	return &Metrics{counters: make(map[string]int64)}
+}
+
+// Add adds delta to the counter
+func (m *Metrics) Add(name string, delta int64) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	if m.counters == nil {
+		m.counters = make(map[string]int64)
+	}
+	m.counters[name] += delta
+	m.mu.Unlock()
+}
+
+// Get returns the value of the counter
+func (m *Metrics) Get(name string) int64 {
+	if m == nil {
+		return 0
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.counters[name]
+}
+
+// Names returns the sorted names of the counters
+func (m *Metrics) Names() []string {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	names := make([]string, 0, len(m.counters))
+	for n := range m.counters {
+		names = append(names, n)
+	}
+	m.mu.Unlock()
+	sort.Strings(names)
+	return names
+}
+
+// String returns the counters in JSON
+func (m *Metrics) String() string {
+	if m == nil {
+		return "{}"
+	}
+	m.mu.Lock()
+	b, _ := json.Marshal(m.counters)
+	m.mu.Unlock()
+	return string(b)
+}
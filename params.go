@@ -0,0 +1,119 @@
+package socks5
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInvalidParams represents the parameters of a username are malformed
+var ErrInvalidParams = errors.New("invalid username parameters")
+
+// AuthInvalidParams is the status of a username with malformed parameters
+const AuthInvalidParams AuthStatus = 0x02
+
+// Parameters of DefaultUsernameParams
+const (
+	// ParamSession keeps the source address of SourceSticky for the session
+	ParamSession = "session"
+	// ParamSessionTime is the minutes the session is kept, StickyTTL if unset
+	ParamSessionTime = "sesstime"
+	// ParamExit is matched by the "param:exit=<group>" conditions of Router
+	ParamExit = "exit"
+)
+
+// DefaultUsernameParams accepts session, sesstime and exit
+var DefaultUsernameParams = &UsernameParams{
+	Keys: map[string]*regexp.Regexp{
+		ParamSession:     regexp.MustCompile(`^[A-Za-z0-9]{1,64}$`),
+		ParamSessionTime: regexp.MustCompile(`^[1-9][0-9]{0,3}$`),
+		ParamExit:        regexp.MustCompile(`^[A-Za-z0-9_]{1,32}$`),
+	},
+}
+
+// UsernameParams parses the parameters of usernames,
+// "alice-session-abc123-exit-us" is the user "alice" with
+// session "abc123" and exit "us". Usernames can't have the separator.
+type UsernameParams struct {
+	// Separator of the username, the keys and the values, "-" if empty
+	Separator string
+
+	// Keys are the accepted keys and the patterns of their values
+	Keys map[string]*regexp.Regexp
+}
+
+// Parse returns the username and its parameters,
+// a username without parameters has nil params
+func (p *UsernameParams) Parse(username string) (name string, params map[string]string, err error) {
+	sep := p.Separator
+	if sep == "" {
+		sep = "-"
+	}
+	fields := strings.Split(username, sep)
+	if len(fields) == 1 {
+		return username, nil, nil
+	}
+	if fields[0] == "" || len(fields)%2 == 0 {
+		return "", nil, fmt.Errorf("%w : %s", ErrInvalidParams, username)
+	}
+	params = make(map[string]string, len(fields)/2)
+	for i := 1; i < len(fields); i += 2 {
+		k, v := fields[i], fields[i+1]
+		re, ok := p.Keys[k]
+		if !ok {
+			return "", nil, fmt.Errorf("%w : unknown key %q", ErrInvalidParams, k)
+		}
+		if _, dup := params[k]; dup {
+			return "", nil, fmt.Errorf("%w : duplicate key %q", ErrInvalidParams, k)
+		}
+		if re != nil && !re.MatchString(v) {
+			return "", nil, fmt.Errorf("%w : %s %q", ErrInvalidParams, k, v)
+		}
+		params[k] = v
+	}
+	return fields[0], params, nil
+}
+
+// parseParams strips the parameters of the username of auth
+func (s *Server) parseParams(auth *Authentication) error {
+	if s.UsernameParams == nil || auth == nil {
+		return nil
+	}
+	name, params, err := s.UsernameParams.Parse(string(auth.Username))
+	if err != nil {
+		return err
+	}
+	auth.Username, auth.Params = []byte(name), params
+	return nil
+}
+
+// setParams applies the session parameters to the connection
+func (info *connInfo) setParams(auth *Authentication) {
+	if auth == nil {
+		return
+	}
+	// the sessions of users are apart
+	if id, ok := auth.Params[ParamSession]; ok {
+		info.session = string(auth.Username) + "\x00" + id
+	}
+	if m, err := strconv.Atoi(auth.Params[ParamSessionTime]); err == nil {
+		info.sessionTTL = time.Duration(m) * time.Minute
+	}
+}
+
+func sendAuthInvalidParams(w io.Writer) error {
+	_, err := w.Write([]byte{Version5, byte(AuthInvalidParams)})
+	return err
+}
+
+// MatchParam matches the authenticated users having the username parameter
+func MatchParam(key, value string) Condition {
+	return func(ctx context.Context, auth *Authentication, req *Request) bool {
+		return value != "" && auth != nil && auth.Params[key] == value
+	}
+}
@@ -0,0 +1,91 @@
+package socks5
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestUsernameParams(t *testing.T) {
+	p := DefaultUsernameParams
+	name, params, err := p.Parse("alice-session-abc123-exit-us")
+	if err != nil || name != "alice" || params[ParamSession] != "abc123" || params[ParamExit] != "us" {
+		t.Fatal(name, params, err)
+	}
+	if name, params, err := p.Parse("alice"); err != nil || name != "alice" || params != nil {
+		t.Fatal(name, params, err)
+	}
+	for _, username := range []string{
+		"alice-session",
+		"alice-country-us",
+		"alice-session-a-session-b",
+		"alice-sesstime-0",
+		"alice-session-a_b",
+		"-session-abc",
+	} {
+		if _, _, err := p.Parse(username); !errors.Is(err, ErrInvalidParams) {
+			t.Fatal(username, err)
+		}
+	}
+	if name, _, err := (&UsernameParams{Separator: "+", Keys: p.Keys}).Parse("a-b+exit+us"); err != nil || name != "a-b" {
+		t.Fatal(name, err)
+	}
+
+	cond, err := ParseCondition("param:exit=us")
+	if err != nil || !cond(context.Background(), &Authentication{Params: params}, nil) ||
+		cond(context.Background(), &Authentication{}, nil) {
+		t.Fatal(err)
+	}
+
+	info := &connInfo{}
+	info.setParams(&Authentication{Username: []byte("alice"),
+		Params: map[string]string{ParamSession: "abc", ParamSessionTime: "30"}})
+	if info.sessionKey() != "alice\x00abc" || info.sessionTTL != 30*time.Minute {
+		t.Fatal(info.sessionKey(), info.sessionTTL)
+	}
+}
+
+func TestServerUsernameParams(t *testing.T) {
+	auths := make(chan *Authentication, 1)
+	s := NewServerWithAuth("alice", "password")
+	s.UsernameParams = DefaultUsernameParams
+	s.HandleRequest = func(ctx context.Context, auth *Authentication, req *Request) (
+		*Reply, io.ReadWriteCloser, error) {
+		auths <- auth
+		return HandleRequestSkip(ctx, auth, req)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go s.Serve(l)
+
+	dial := func(username, password string) error {
+		c, err := NewClientWithAuth(l.Addr().String(), username, password)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn, err := c.Dial("tcp", "192.0.2.1:80")
+		if err == nil {
+			conn.Close()
+		}
+		return err
+	}
+	if err := dial("alice-session-abc-exit-us", "password"); err != nil {
+		t.Fatal(err)
+	}
+	auth := <-auths
+	if string(auth.Username) != "alice" || auth.Params[ParamExit] != "us" {
+		t.Fatal(auth)
+	}
+	if err := dial("alice-session-abc", "wrong"); !errors.Is(err, ErrAuthFailed) {
+		t.Fatal(err)
+	}
+	if err := dial("alice-color-red", "password"); !errors.Is(err, ErrInvalidParams) {
+		t.Fatal(err)
+	}
+}
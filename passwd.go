@@ -0,0 +1,344 @@
+package socks5
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"strconv"
+	"strings"
+)
+
+// ErrUnknownScheme represents a password hash scheme is unknown
+var ErrUnknownScheme = errors.New("unknown password scheme")
+
+// Password hash schemes
+const (
+	SchemeSHA512Crypt  = "sha512-crypt"  // $6$[rounds=N$]salt$hash
+	SchemePBKDF2SHA256 = "pbkdf2-sha256" // $pbkdf2-sha256$rounds$salt$hash
+	SchemePBKDF2SHA512 = "pbkdf2-sha512" // $pbkdf2-sha512$rounds$salt$hash
+	SchemeAPR1         = "apr1"          // $apr1$salt$hash of htpasswd
+	SchemeSHA          = "sha"           // {SHA}hash of htpasswd, unsalted
+)
+
+// DefaultScheme is the scheme of HashPassword if none is given
+const DefaultScheme = SchemeSHA512Crypt
+
+const (
+	sha512CryptRounds  = 5000
+	pbkdf2SHA256Rounds = 29000
+	pbkdf2SHA512Rounds = 25000
+)
+
+// HashPassword hashes the password with a random salt.
+// The PBKDF2 formats are those of passlib.
+func HashPassword(password, scheme string) (string, error) {
+	if scheme == "" {
+		scheme = DefaultScheme
+	}
+	switch scheme {
+	case SchemeSHA512Crypt:
+		salt, err := cryptSalt(16)
+		if err != nil {
+			return "", err
+		}
+		return sha512Crypt([]byte(password), salt, sha512CryptRounds, false), nil
+	case SchemePBKDF2SHA256, SchemePBKDF2SHA512:
+		salt := make([]byte, 16)
+		if _, err := rand.Read(salt); err != nil {
+			return "", err
+		}
+		h, rounds := sha256.New, pbkdf2SHA256Rounds
+		if scheme == SchemePBKDF2SHA512 {
+			h, rounds = sha512.New, pbkdf2SHA512Rounds
+		}
+		return formatPBKDF2(scheme, []byte(password), salt, rounds, h), nil
+	case SchemeAPR1:
+		salt, err := cryptSalt(8)
+		if err != nil {
+			return "", err
+		}
+		return apr1Crypt([]byte(password), salt), nil
+	case SchemeSHA:
+		sum := sha1.Sum([]byte(password))
+		return "{SHA}" + base64.StdEncoding.EncodeToString(sum[:]), nil
+	}
+	return "", fmt.Errorf("%w : %s", ErrUnknownScheme, scheme)
+}
+
+// VerifyPassword reports whether the password matches the hash
+// of any scheme of HashPassword
+func VerifyPassword(hashed, password string) bool {
+	var computed string
+	switch {
+	case strings.HasPrefix(hashed, "$6$"):
+		fields := strings.Split(hashed[3:], "$")
+		rounds, custom := sha512CryptRounds, false
+		if len(fields) == 3 && strings.HasPrefix(fields[0], "rounds=") {
+			n, err := strconv.Atoi(fields[0][len("rounds="):])
+			if err != nil {
+				return false
+			}
+			rounds, custom = n, true
+			fields = fields[1:]
+		}
+		if len(fields) != 2 {
+			return false
+		}
+		computed = sha512Crypt([]byte(password), []byte(fields[0]), rounds, custom)
+	case strings.HasPrefix(hashed, "$pbkdf2-sha256$"), strings.HasPrefix(hashed, "$pbkdf2-sha512$"):
+		fields := strings.Split(hashed[1:], "$")
+		if len(fields) != 4 {
+			return false
+		}
+		rounds, err := strconv.Atoi(fields[1])
+		if err != nil || rounds <= 0 {
+			return false
+		}
+		salt, err := ab64Decode(fields[2])
+		if err != nil {
+			return false
+		}
+		h := sha256.New
+		if fields[0] == SchemePBKDF2SHA512 {
+			h = sha512.New
+		}
+		computed = formatPBKDF2(fields[0], []byte(password), salt, rounds, h)
+	case strings.HasPrefix(hashed, "$apr1$"):
+		fields := strings.Split(hashed[6:], "$")
+		if len(fields) != 2 {
+			return false
+		}
+		computed = apr1Crypt([]byte(password), []byte(fields[0]))
+	case strings.HasPrefix(hashed, "{SHA}"):
+		sum := sha1.Sum([]byte(password))
+		computed = "{SHA}" + base64.StdEncoding.EncodeToString(sum[:])
+	default:
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(computed), []byte(hashed)) == 1
+}
+
+const cryptAlphabet = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+func cryptSalt(n int) ([]byte, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	for i := range b {
+		b[i] = cryptAlphabet[b[i]&0x3f]
+	}
+	return b, nil
+}
+
+// cryptEncode encodes the bytes at the indices in groups of three,
+// the last group may be shorter
+func cryptEncode(b []byte, indices []int) []byte {
+	var out []byte
+	for i := 0; i < len(indices); i += 3 {
+		var w uint
+		n := 0
+		for j := i; j < i+3 && j < len(indices); j++ {
+			w = w<<8 | uint(b[indices[j]])
+			n++
+		}
+		// n bytes take n+1 characters
+		for k := 0; k <= n; k++ {
+			out = append(out, cryptAlphabet[w&0x3f])
+			w >>= 6
+		}
+	}
+	return out
+}
+
+// repeatTo repeats the block to n bytes
+func repeatTo(block []byte, n int) []byte {
+	out := make([]byte, 0, n)
+	for len(out) < n {
+		out = append(out, block[:min(len(block), n-len(out))]...)
+	}
+	return out
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+var sha512CryptOrder = []int{
+	0, 21, 42, 22, 43, 1, 44, 2, 23, 3, 24, 45, 25, 46, 4,
+	47, 5, 26, 6, 27, 48, 28, 49, 7, 50, 8, 29, 9, 30, 51,
+	31, 52, 10, 53, 11, 32, 12, 33, 54, 34, 55, 13, 56, 14, 35,
+	15, 36, 57, 37, 58, 16, 59, 17, 38, 18, 39, 60, 40, 61, 19,
+	62, 20, 41, 63,
+}
+
+// sha512Crypt is the SHA-512 crypt of Ulrich Drepper
+func sha512Crypt(password, salt []byte, rounds int, custom bool) string {
+	if len(salt) > 16 {
+		salt = salt[:16]
+	}
+	if rounds < 1000 {
+		rounds = 1000
+	} else if rounds > 999999999 {
+		rounds = 999999999
+	}
+
+	h := sha512.New()
+	h.Write(password)
+	h.Write(salt)
+	h.Write(password)
+	b := h.Sum(nil)
+
+	h.Reset()
+	h.Write(password)
+	h.Write(salt)
+	h.Write(repeatTo(b, len(password)))
+	for i := len(password); i > 0; i >>= 1 {
+		if i&1 != 0 {
+			h.Write(b)
+		} else {
+			h.Write(password)
+		}
+	}
+	a := h.Sum(nil)
+
+	h.Reset()
+	for i := 0; i < len(password); i++ {
+		h.Write(password)
+	}
+	p := repeatTo(h.Sum(nil), len(password))
+
+	h.Reset()
+	for i := 0; i < 16+int(a[0]); i++ {
+		h.Write(salt)
+	}
+	s := repeatTo(h.Sum(nil), len(salt))
+
+	for r := 0; r < rounds; r++ {
+		h.Reset()
+		if r&1 != 0 {
+			h.Write(p)
+		} else {
+			h.Write(a)
+		}
+		if r%3 != 0 {
+			h.Write(s)
+		}
+		if r%7 != 0 {
+			h.Write(p)
+		}
+		if r&1 != 0 {
+			h.Write(a)
+		} else {
+			h.Write(p)
+		}
+		a = h.Sum(a[:0])
+	}
+
+	prefix := "$6$"
+	if custom {
+		prefix += "rounds=" + strconv.Itoa(rounds) + "$"
+	}
+	return prefix + string(salt) + "$" + string(cryptEncode(a, sha512CryptOrder))
+}
+
+var apr1Order = []int{0, 6, 12, 1, 7, 13, 2, 8, 14, 3, 9, 15, 4, 10, 5, 11}
+
+// apr1Crypt is the MD5 crypt of htpasswd
+func apr1Crypt(password, salt []byte) string {
+	const magic = "$apr1$"
+	if len(salt) > 8 {
+		salt = salt[:8]
+	}
+
+	h := md5.New()
+	h.Write(password)
+	h.Write(salt)
+	h.Write(password)
+	b := h.Sum(nil)
+
+	h.Reset()
+	h.Write(password)
+	h.Write([]byte(magic))
+	h.Write(salt)
+	h.Write(repeatTo(b, len(password)))
+	for i := len(password); i > 0; i >>= 1 {
+		if i&1 != 0 {
+			h.Write([]byte{0})
+		} else {
+			h.Write(password[:1])
+		}
+	}
+	a := h.Sum(nil)
+
+	for r := 0; r < 1000; r++ {
+		h.Reset()
+		if r&1 != 0 {
+			h.Write(password)
+		} else {
+			h.Write(a)
+		}
+		if r%3 != 0 {
+			h.Write(salt)
+		}
+		if r%7 != 0 {
+			h.Write(password)
+		}
+		if r&1 != 0 {
+			h.Write(a)
+		} else {
+			h.Write(password)
+		}
+		a = h.Sum(a[:0])
+	}
+	return magic + string(salt) + "$" + string(cryptEncode(a, apr1Order))
+}
+
+// pbkdf2 is the key derivation of RFC 8018
+func pbkdf2(password, salt []byte, rounds, keyLen int, h func() hash.Hash) []byte {
+	prf := hmac.New(h, password)
+	var key []byte
+	for block := uint32(1); len(key) < keyLen; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		prf.Write([]byte{byte(block >> 24), byte(block >> 16), byte(block >> 8), byte(block)})
+		u := prf.Sum(nil)
+		t := append([]byte(nil), u...)
+		for i := 1; i < rounds; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+		key = append(key, t...)
+	}
+	return key[:keyLen]
+}
+
+func formatPBKDF2(scheme string, password, salt []byte, rounds int, h func() hash.Hash) string {
+	key := pbkdf2(password, salt, rounds, h().Size(), h)
+	return "$" + scheme + "$" + strconv.Itoa(rounds) + "$" +
+		ab64Encode(salt) + "$" + ab64Encode(key)
+}
+
+// ab64 is the base64 of passlib, '.' for '+' and no padding
+func ab64Encode(b []byte) string {
+	return strings.Replace(base64.RawStdEncoding.EncodeToString(b), "+", ".", -1)
+}
+
+func ab64Decode(s string) ([]byte, error) {
+	return base64.RawStdEncoding.DecodeString(strings.Replace(s, ".", "+", -1))
+}
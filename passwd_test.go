@@ -0,0 +1,41 @@
+package socks5
+
+import (
+	"testing"
+)
+
+func TestVerifyPassword(t *testing.T) {
+	t1 := map[string]string{
+		"$6$saltstring$svn8UoSVapNtMuq1ukKS4tPQd8iKwSMHWjl/O817G3uBnIFNjnQJuesI68u4OTLiBFdcbYEdFCoEOfaS35inz1":                    "Hello world!",
+		"$6$rounds=10000$saltstringsaltst$OW1/O6BYHV6BcXZu8QVeXbDWra3Oeqh0sbHbbMCVNSnCM/UrjmM0Dp8vOuZeHBy/YTBmSK6H9qs/y3RnOaw5v.": "Hello world!",
+		"$pbkdf2-sha256$1000$c2FsdHNhbHQ$E196ZhRPzw.wA84EjzHwJO1cv/MFJdO6C/sxmUeTYqY":                                             "password",
+		"$pbkdf2-sha512$1000$c2FsdHNhbHQ$Q6v4xwJ8a9nWPp2BeEoAYYhHSo2xRmPWART17vTpSxt2q6iNp7BOozW557qqa95eNjUO4gKs0CyvJbYGGku1tA":  "password",
+		"$apr1$r31.....$HqJZimcKQFAMYayBlzkrA/": "myPassword",
+		"$apr1$abcdefgh$L.PT565ESX4Tp2bqNs7Ie.": "",
+		"{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=":     "password",
+	}
+	for hash, password := range t1 {
+		if !VerifyPassword(hash, password) {
+			t.Fatal(hash)
+		}
+		if VerifyPassword(hash, password+"x") {
+			t.Fatal(hash)
+		}
+	}
+
+	for _, scheme := range []string{"", SchemePBKDF2SHA256, SchemePBKDF2SHA512, SchemeAPR1, SchemeSHA} {
+		hash, err := HashPassword("secret", scheme)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !VerifyPassword(hash, "secret") || VerifyPassword(hash, "Secret") {
+			t.Fatal(scheme, hash)
+		}
+	}
+	if _, err := HashPassword("secret", "md5"); err == nil {
+		t.Fatal("Error")
+	}
+	if VerifyPassword("plain", "plain") {
+		t.Fatal("Error")
+	}
+}
@@ -0,0 +1,211 @@
+package socks5
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Usage is the bytes a user transferred
+type Usage struct {
+	Day        string `json:"day"`   // 2006-01-02
+	Month      string `json:"month"` // 2006-01
+	DayBytes   int64  `json:"day_bytes"`
+	MonthBytes int64  `json:"month_bytes"`
+	TotalBytes int64  `json:"total_bytes"`
+}
+
+func (u *Usage) roll(now time.Time) {
+	if day := now.Format("2006-01-02"); u.Day != day {
+		u.Day, u.DayBytes = day, 0
+	}
+	if month := now.Format("2006-01"); u.Month != month {
+		u.Month, u.MonthBytes = month, 0
+	}
+}
+
+// Quota accounts the bytes authenticated users transfer,
+// in both directions, and enforces the daily and monthly limits.
+// Requests of an exhausted user are refused with ReplyConnectionNotAllowed.
+type Quota struct {
+	// Limits in bytes, zero is unlimited
+	Daily   int64
+	Monthly int64
+	Users   map[string][2]int64 // daily, monthly
+
+	// CutActive closes the sessions of a user once the quota is exhausted
+	CutActive bool
+
+	// Filename persists the usage in JSON, see Save and Run
+	Filename string
+
+	mu    sync.Mutex
+	usage map[string]*Usage
+}
+
+// LoadQuota creates a Quota persisted in the file,
+// the usage is loaded if the file exists.
+func LoadQuota(filename string) (*Quota, error) {
+	q := &Quota{Filename: filename}
+	b, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return q, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &q.usage); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+func (q *Quota) limits(username string) (daily, monthly int64) {
+	if l, ok := q.Users[username]; ok {
+		return l[0], l[1]
+	}
+	return q.Daily, q.Monthly
+}
+
+func (q *Quota) get(username string, now time.Time) *Usage {
+	if q.usage == nil {
+		q.usage = make(map[string]*Usage)
+	}
+	u, ok := q.usage[username]
+	if !ok {
+		u = &Usage{}
+		q.usage[username] = u
+	}
+	u.roll(now)
+	return u
+}
+
+func (q *Quota) exhausted(username string, u *Usage) bool {
+	daily, monthly := q.limits(username)
+	return daily > 0 && u.DayBytes >= daily ||
+		monthly > 0 && u.MonthBytes >= monthly
+}
+
+// Add accounts n bytes, it reports whether the quota is exhausted
+func (q *Quota) Add(username string, n int64) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	u := q.get(username, time.Now())
+	u.DayBytes += n
+	u.MonthBytes += n
+	u.TotalBytes += n
+	return q.exhausted(username, u)
+}
+
+// Exhausted reports whether the user has no quota left
+func (q *Quota) Exhausted(username string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.exhausted(username, q.get(username, time.Now()))
+}
+
+// Usage returns a copy of the usage of the user
+func (q *Quota) Usage(username string) Usage {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return *q.get(username, time.Now())
+}
+
+// Save writes the usage to Filename atomically
+func (q *Quota) Save() error {
+	q.mu.Lock()
+	b, err := json.MarshalIndent(q.usage, "", "  ")
+	q.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(q.Filename), ".quota")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), q.Filename)
+}
+
+// Run saves the usage every interval until ctx is done,
+// then saves it once more.
+func (q *Quota) Run(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := q.Save(); err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return q.Save()
+		}
+	}
+}
+
+// WriteCSV writes the usage report of all users
+func (q *Quota) WriteCSV(w io.Writer) error {
+	q.mu.Lock()
+	names := make([]string, 0, len(q.usage))
+	for n := range q.usage {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	records := [][]string{
+		{"user", "day", "day_bytes", "month", "month_bytes", "total_bytes"},
+	}
+	for _, n := range names {
+		u := q.usage[n]
+		records = append(records, []string{n,
+			u.Day, strconv.FormatInt(u.DayBytes, 10),
+			u.Month, strconv.FormatInt(u.MonthBytes, 10),
+			strconv.FormatInt(u.TotalBytes, 10)})
+	}
+	q.mu.Unlock()
+	return csv.NewWriter(w).WriteAll(records)
+}
+
+// countingReader accounts the bytes read
+type countingReader struct {
+	r     io.Reader
+	count func(n int)
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.count(n)
+	}
+	return n, err
+}
+
+// countingWriter accounts the bytes written
+type countingWriter struct {
+	w     io.Writer
+	count func(n int)
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	if n > 0 {
+		w.count(n)
+	}
+	return n, err
+}
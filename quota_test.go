@@ -0,0 +1,114 @@
+package socks5
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestQuota(t *testing.T) {
+	dir, err := ioutil.TempDir("", "socks5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "usage.json")
+
+	q, err := LoadQuota(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.Daily = 100
+	q.Users = map[string][2]int64{"vip": {0, 1000}}
+	if q.Add("alice", 60) || !q.Add("alice", 60) || !q.Exhausted("alice") {
+		t.Fatal("daily quota")
+	}
+	if q.Add("vip", 500) || !q.Add("vip", 500) {
+		t.Fatal("monthly quota")
+	}
+
+	// a new day
+	q.usage["alice"].Day = "2000-01-01"
+	if q.Exhausted("alice") || q.Usage("alice").TotalBytes != 120 {
+		t.Fatal(q.Usage("alice"))
+	}
+
+	if err := q.Save(); err != nil {
+		t.Fatal(err)
+	}
+	q2, err := LoadQuota(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q2.Usage("vip").MonthBytes != 1000 {
+		t.Fatal(q2.Usage("vip"))
+	}
+	var buf bytes.Buffer
+	if err := q2.WriteCSV(&buf); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[2], "vip,") ||
+		!strings.HasSuffix(lines[2], ",1000,1000") {
+		t.Fatal(buf.String())
+	}
+}
+
+func TestQuotaEnforce(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	s := NewServerWithAuth("user", "password")
+	s.Quota = &Quota{Daily: 1000, CutActive: true}
+	l2, err := net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l2.Close()
+	go s.Serve(l2)
+
+	c, err := NewClientWithAuth(l2.Addr().String(), "user", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := c.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// echo of 600 bytes counts 1200
+	data := make([]byte, 600)
+	if _, err := conn.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	if n, err := io.Copy(ioutil.Discard, conn); err != nil || n > int64(len(data)) {
+		t.Fatal(n, err)
+	}
+	if _, err := c.Dial("tcp", l.Addr().String()); !errors.Is(err, ErrReplyFailure) {
+		t.Fatal(err)
+	}
+}
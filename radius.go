@@ -0,0 +1,259 @@
+package socks5
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// ErrRADIUS represents a RADIUS exchange failed
+var ErrRADIUS = errors.New("radius exchange failed")
+
+// Default values of RADIUS
+const (
+	DefaultRADIUSTimeout = 3 * time.Second
+	DefaultRADIUSRetries = 2
+)
+
+// RADIUS codes and attributes
+const (
+	radiusAccessRequest = 1
+	radiusAccessAccept  = 2
+	radiusAccessReject  = 3
+
+	radiusUserName             = 1
+	radiusUserPassword         = 2
+	radiusFilterID             = 11
+	radiusReplyMessage         = 18
+	radiusClass                = 25
+	radiusNASIdentifier        = 32
+	radiusMessageAuthenticator = 80
+)
+
+// radiusAttributes names the Access-Accept attributes kept in
+// Authentication.Attributes
+var radiusAttributes = map[byte]string{
+	radiusFilterID:     "filter-id",
+	radiusReplyMessage: "reply-message",
+	radiusClass:        "class",
+}
+
+// RADIUS authenticates with PAP Access-Requests to RADIUS servers.
+// Servers are tried in order, each Retries times after the first attempt,
+// starting from the last one that answered. The Class and Filter-Id of
+// Access-Accept are set as the "class" and "filter-id" attributes.
+type RADIUS struct {
+	Servers       []string // host:port
+	Secret        string
+	Timeout       time.Duration // of an attempt
+	Retries       int           // negative is no retry
+	NASIdentifier string
+
+	mu   sync.Mutex
+	last int
+}
+
+// Authenticate is a Server.Authenticate
+func (r *RADIUS) Authenticate(ctx context.Context, auth *Authentication) bool {
+	if auth == nil {
+		return false
+	}
+	attrs, err := r.Check(ctx, string(auth.Username), string(auth.Password))
+	if err != nil {
+		return false
+	}
+	auth.Attributes = attrs
+	return true
+}
+
+// Check sends an Access-Request, it returns the attributes of
+// Access-Accept or ErrAuthFailed on Access-Reject.
+func (r *RADIUS) Check(ctx context.Context, username, password string) (map[string][]string, error) {
+	if len(r.Servers) == 0 {
+		return nil, fmt.Errorf("%w : no server", ErrRADIUS)
+	}
+	retries := DefaultRADIUSRetries
+	if r.Retries != 0 {
+		retries = r.Retries
+	}
+	if retries < 0 {
+		retries = 0
+	}
+	r.mu.Lock()
+	start := r.last
+	r.mu.Unlock()
+
+	var err error
+	for i := range r.Servers {
+		n := (start + i) % len(r.Servers)
+		for j := 0; j <= retries; j++ {
+			var attrs map[string][]string
+			attrs, err = r.exchange(ctx, r.Servers[n], username, password)
+			if err == nil || errors.Is(err, ErrAuthFailed) {
+				r.mu.Lock()
+				r.last = n
+				r.mu.Unlock()
+				return attrs, err
+			}
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+		}
+	}
+	return nil, err
+}
+
+func (r *RADIUS) exchange(ctx context.Context, server, username, password string) (map[string][]string, error) {
+	timeout := r.Timeout
+	if timeout <= 0 {
+		timeout = DefaultRADIUSTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := r.newRequest(username, password)
+	if err != nil {
+		return nil, err
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "udp", server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if _, err := conn.Write(req); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 4096)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		// ignore the stray responses
+		if n >= 20 && buf[1] == req[1] {
+			return r.parseResponse(buf[:n], req[4:20])
+		}
+	}
+}
+
+func (r *RADIUS) newRequest(username, password string) ([]byte, error) {
+	if len(username) > 253 || len(password) > 128 {
+		return nil, ErrInvalidAuth
+	}
+	hdr := make([]byte, 20)
+	if _, err := rand.Read(hdr[1:20]); err != nil {
+		return nil, err
+	}
+	hdr[0] = radiusAccessRequest
+	authenticator := hdr[4:20]
+
+	var attrs bytes.Buffer
+	writeRADIUSAttr(&attrs, radiusUserName, []byte(username))
+	writeRADIUSAttr(&attrs, radiusUserPassword, r.hidePassword([]byte(password), authenticator))
+	if r.NASIdentifier != "" {
+		writeRADIUSAttr(&attrs, radiusNASIdentifier, []byte(r.NASIdentifier))
+	}
+	// Message-Authenticator is signed at last
+	writeRADIUSAttr(&attrs, radiusMessageAuthenticator, make([]byte, 16))
+
+	pkt := append(hdr, attrs.Bytes()...)
+	binary.BigEndian.PutUint16(pkt[2:], uint16(len(pkt)))
+	mac := hmac.New(md5.New, []byte(r.Secret))
+	mac.Write(pkt)
+	copy(pkt[len(pkt)-16:], mac.Sum(nil))
+	return pkt, nil
+}
+
+// hidePassword obfuscates User-Password as RFC 2865 section 5.2
+func (r *RADIUS) hidePassword(password, authenticator []byte) []byte {
+	n := (len(password) + 15) / 16 * 16
+	if n == 0 {
+		n = 16
+	}
+	out := make([]byte, n)
+	copy(out, password)
+	prev := authenticator
+	for i := 0; i < n; i += 16 {
+		h := md5.New()
+		h.Write([]byte(r.Secret))
+		h.Write(prev)
+		b := h.Sum(nil)
+		for j := 0; j < 16; j++ {
+			out[i+j] ^= b[j]
+		}
+		prev = out[i : i+16]
+	}
+	return out
+}
+
+func (r *RADIUS) parseResponse(pkt, reqAuthenticator []byte) (map[string][]string, error) {
+	length := int(binary.BigEndian.Uint16(pkt[2:]))
+	if length < 20 || length > len(pkt) {
+		return nil, fmt.Errorf("%w : invalid length", ErrRADIUS)
+	}
+	pkt = pkt[:length]
+
+	// Response Authenticator
+	h := md5.New()
+	h.Write(pkt[:4])
+	h.Write(reqAuthenticator)
+	h.Write(pkt[20:])
+	h.Write([]byte(r.Secret))
+	if !hmac.Equal(h.Sum(nil), pkt[4:20]) {
+		return nil, fmt.Errorf("%w : invalid authenticator", ErrRADIUS)
+	}
+
+	attrs := make(map[string][]string)
+	for b := pkt[20:]; len(b) > 0; {
+		if len(b) < 2 || b[1] < 2 || int(b[1]) > len(b) {
+			return nil, fmt.Errorf("%w : invalid attribute", ErrRADIUS)
+		}
+		typ, value := b[0], b[2:b[1]]
+		if typ == radiusMessageAuthenticator {
+			if len(value) != 16 ||
+				!r.validMessageAuthenticator(pkt, reqAuthenticator, len(pkt)-len(b)+2) {
+				return nil, fmt.Errorf("%w : invalid message authenticator", ErrRADIUS)
+			}
+		}
+		if name, ok := radiusAttributes[typ]; ok {
+			attrs[name] = append(attrs[name], string(value))
+		}
+		b = b[b[1]:]
+	}
+
+	switch pkt[0] {
+	case radiusAccessAccept:
+		return attrs, nil
+	case radiusAccessReject:
+		return nil, ErrAuthFailed
+	}
+	return nil, fmt.Errorf("%w : unexpected code %d", ErrRADIUS, pkt[0])
+}
+
+// validMessageAuthenticator checks the Message-Authenticator at offset
+func (r *RADIUS) validMessageAuthenticator(pkt, reqAuthenticator []byte, offset int) bool {
+	c := append([]byte(nil), pkt...)
+	copy(c[4:20], reqAuthenticator)
+	copy(c[offset:offset+16], make([]byte, 16))
+	mac := hmac.New(md5.New, []byte(r.Secret))
+	mac.Write(c)
+	return hmac.Equal(mac.Sum(nil), pkt[offset:offset+16])
+}
+
+func writeRADIUSAttr(b *bytes.Buffer, typ byte, value []byte) {
+	b.WriteByte(typ)
+	b.WriteByte(byte(len(value) + 2))
+	b.Write(value)
+}
@@ -0,0 +1,103 @@
+package socks5
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// testRADIUSServer answers Access-Requests of the users
+func testRADIUSServer(t *testing.T, secret string, users map[string]string) net.PacketConn {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		buf := make([]byte, 4096)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			req := buf[:n]
+			var username, password []byte
+			for b := req[20:]; len(b) >= 2; b = b[b[1]:] {
+				switch b[0] {
+				case radiusUserName:
+					username = b[2:b[1]]
+				case radiusUserPassword:
+					c, prev := b[2:b[1]], req[4:20]
+					for i := 0; i < len(c); i += 16 {
+						x := md5.Sum(append([]byte(secret), prev...))
+						for j := 0; j < 16; j++ {
+							password = append(password, c[i+j]^x[j])
+						}
+						prev = c[i : i+16]
+					}
+					password = bytes.TrimRight(password, "\x00")
+				}
+			}
+
+			var attrs bytes.Buffer
+			code := byte(radiusAccessReject)
+			if p, ok := users[string(username)]; ok && p == string(password) {
+				code = radiusAccessAccept
+				writeRADIUSAttr(&attrs, radiusClass, []byte("premium"))
+				writeRADIUSAttr(&attrs, radiusFilterID, []byte("web"))
+			}
+			resp := append([]byte{code, req[1], 0, 0}, req[4:20]...)
+			resp = append(resp, attrs.Bytes()...)
+			binary.BigEndian.PutUint16(resp[2:], uint16(len(resp)))
+			sum := md5.Sum(append(append([]byte(nil), resp...), secret...))
+			copy(resp[4:20], sum[:])
+			conn.WriteTo(resp, addr)
+		}
+	}()
+	return conn
+}
+
+func TestRADIUS(t *testing.T) {
+	// the first server never answers
+	dead, err := net.ListenPacket("udp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dead.Close()
+	live := testRADIUSServer(t, "secret", map[string]string{
+		"alice": "a password longer than sixteen bytes",
+	})
+	defer live.Close()
+
+	r := &RADIUS{
+		Servers: []string{dead.LocalAddr().String(), live.LocalAddr().String()},
+		Secret:  "secret",
+		Timeout: 50 * time.Millisecond,
+		Retries: 1,
+	}
+	ctx := context.Background()
+	auth := &Authentication{Username: []byte("alice"),
+		Password: []byte("a password longer than sixteen bytes")}
+	if !r.Authenticate(ctx, auth) ||
+		!MatchAttribute("class", "premium")(ctx, auth, nil) ||
+		auth.Attributes["filter-id"][0] != "web" {
+		t.Fatal(auth.Attributes)
+	}
+	// the live server is tried first from now on
+	start := time.Now()
+	if _, err := r.Check(ctx, "alice", "wrong"); !errors.Is(err, ErrAuthFailed) {
+		t.Fatal(err)
+	}
+	if time.Since(start) > r.Timeout {
+		t.Fatal(time.Since(start))
+	}
+
+	r.Servers, r.Secret = r.Servers[1:], "bad secret"
+	if _, err := r.Check(ctx, "alice", "a password longer than sixteen bytes"); !errors.Is(err, ErrRADIUS) {
+		t.Fatal(err)
+	}
+}
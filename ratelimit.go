@@ -0,0 +1,210 @@
+package socks5
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// Limiter is a token bucket limiting bytes per second.
+// It's safe for concurrent use and a nil *Limiter doesn't limit.
+type Limiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewLimiter creates a Limiter of rate bytes per second,
+// up to burst bytes at once. rate <= 0 is unlimited,
+// burst <= 0 allows one second of rate.
+func NewLimiter(rate, burst int) *Limiter {
+	l := &Limiter{}
+	l.SetLimit(rate, burst)
+	l.tokens = l.burst
+	return l
+}
+
+// SetLimit changes the limit, it takes effect from the next transfer
+func (l *Limiter) SetLimit(rate, burst int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.refill(time.Now())
+	if burst <= 0 {
+		burst = rate
+	}
+	l.rate, l.burst = float64(rate), float64(burst)
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+}
+
+// Limit returns the rate and burst
+func (l *Limiter) Limit() (rate, burst int) {
+	if l == nil {
+		return 0, 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return int(l.rate), int(l.burst)
+}
+
+func (l *Limiter) refill(now time.Time) {
+	if !l.last.IsZero() && l.rate > 0 {
+		l.tokens += now.Sub(l.last).Seconds() * l.rate
+		if l.tokens > l.burst {
+			l.tokens = l.burst
+		}
+	}
+	l.last = now
+}
+
+// WaitN takes n bytes from the bucket, waiting while it's in debt
+func (l *Limiter) WaitN(ctx context.Context, n int) error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	if l.rate <= 0 {
+		l.mu.Unlock()
+		return nil
+	}
+	l.refill(time.Now())
+	l.tokens -= float64(n)
+	var wait time.Duration
+	if l.tokens < 0 {
+		wait = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+	if wait == 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// chunk is the largest read or write before waiting
+func (l *Limiter) chunk(max int) int {
+	if l == nil {
+		return max
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.rate > 0 && int(l.burst) < max && l.burst >= 1 {
+		return int(l.burst)
+	}
+	return max
+}
+
+type limitedReader struct {
+	ctx      context.Context
+	r        io.Reader
+	limiters []*Limiter
+}
+
+// NewLimitedReader returns a Reader waiting on all the limiters
+func NewLimitedReader(ctx context.Context, r io.Reader, limiters ...*Limiter) io.Reader {
+	return &limitedReader{ctx: ctx, r: r, limiters: limiters}
+}
+
+func (r *limitedReader) Read(p []byte) (int, error) {
+	max := len(p)
+	for _, l := range r.limiters {
+		max = l.chunk(max)
+	}
+	n, err := r.r.Read(p[:max])
+	for _, l := range r.limiters {
+		if e := l.WaitN(r.ctx, n); e != nil && err == nil {
+			err = e
+		}
+	}
+	return n, err
+}
+
+type limitedWriter struct {
+	ctx      context.Context
+	w        io.Writer
+	limiters []*Limiter
+}
+
+// NewLimitedWriter returns a Writer waiting on all the limiters
+func NewLimitedWriter(ctx context.Context, w io.Writer, limiters ...*Limiter) io.Writer {
+	return &limitedWriter{ctx: ctx, w: w, limiters: limiters}
+}
+
+func (w *limitedWriter) Write(p []byte) (written int, err error) {
+	for len(p) > 0 {
+		max := len(p)
+		for _, l := range w.limiters {
+			max = l.chunk(max)
+		}
+		for _, l := range w.limiters {
+			if err = l.WaitN(w.ctx, max); err != nil {
+				return
+			}
+		}
+		var n int
+		n, err = w.w.Write(p[:max])
+		written += n
+		if err != nil {
+			return
+		}
+		p = p[max:]
+	}
+	return
+}
+
+// Rate is a limit of bytes per second, zero Bytes is unlimited
+type Rate struct {
+	Bytes int
+	Burst int
+}
+
+// Bandwidth limits the relay of a Server.
+// Upload is from client to target, Download is from target to client.
+type Bandwidth struct {
+	// Global limiters are shared by all connections
+	Upload   *Limiter
+	Download *Limiter
+
+	// Each authenticated user gets limiters of UserUpload and UserDownload
+	// shared by the connections of the user, Users overrides them.
+	UserUpload   Rate
+	UserDownload Rate
+	Users        map[string][2]Rate // upload, download
+
+	// Each connection gets its own limiters
+	ConnUpload   Rate
+	ConnDownload Rate
+
+	mu    sync.Mutex
+	users map[string][2]*Limiter
+}
+
+// User returns the limiters of the user, creating them on first use.
+// The limits can be changed at runtime with SetLimit.
+func (b *Bandwidth) User(username string) (upload, download *Limiter) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if l, ok := b.users[username]; ok {
+		return l[0], l[1]
+	}
+	up, down := b.UserUpload, b.UserDownload
+	if r, ok := b.Users[username]; ok {
+		up, down = r[0], r[1]
+	}
+	if b.users == nil {
+		b.users = make(map[string][2]*Limiter)
+	}
+	l := [2]*Limiter{NewLimiter(up.Bytes, up.Burst), NewLimiter(down.Bytes, down.Burst)}
+	b.users[username] = l
+	return l[0], l[1]
+}
@@ -0,0 +1,106 @@
+package socks5
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	ctx := context.Background()
+	data := make([]byte, 60000)
+
+	// 20000 bytes of burst, then 100000 bytes per second
+	l := NewLimiter(100000, 20000)
+	start := time.Now()
+	n, err := io.Copy(ioutil.Discard, NewLimitedReader(ctx, bytes.NewReader(data), l))
+	if err != nil || n != int64(len(data)) {
+		t.Fatal(n, err)
+	}
+	if elapsed := time.Since(start); elapsed < 350*time.Millisecond || elapsed > time.Second {
+		t.Fatal(elapsed)
+	}
+
+	start = time.Now()
+	l.SetLimit(0, 0)
+	w := NewLimitedWriter(ctx, ioutil.Discard, l, nil)
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Fatal(elapsed)
+	}
+
+	b := &Bandwidth{UserUpload: Rate{Bytes: 1000}, Users: map[string][2]Rate{
+		"vip": {{Bytes: 5000}, {}},
+	}}
+	up, _ := b.User("user")
+	if r, burst := up.Limit(); r != 1000 || burst != 1000 {
+		t.Fatal(r, burst)
+	}
+	if u, _ := b.User("user"); u != up {
+		t.Fatal("limiters of a user are not shared")
+	}
+	up, down := b.User("vip")
+	if r, _ := up.Limit(); r != 5000 {
+		t.Fatal(r)
+	}
+	if r, _ := down.Limit(); r != 0 {
+		t.Fatal(r)
+	}
+}
+
+func TestSessionLimit(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	data := make([]byte, 100000)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Write(data)
+	}()
+
+	s := NewServer()
+	s.Bandwidth = &Bandwidth{ConnDownload: Rate{Bytes: 1000}}
+	l2, err := net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l2.Close()
+	go s.Serve(l2)
+
+	c, err := NewClient(l2.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := c.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	buf := make([]byte, 1000)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatal(err)
+	}
+
+	// lift the limit of the active session
+	sessions := s.Sessions()
+	if len(sessions) != 1 {
+		t.Fatal(len(sessions))
+	}
+	sessions[0].Download.SetLimit(0, 0)
+	conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	if n, err := io.Copy(ioutil.Discard, conn); err != nil || n != int64(len(data)-len(buf)) {
+		t.Fatal(n, err)
+	}
+}
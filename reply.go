@@ -28,6 +28,23 @@ const (
 // ErrReplyFailure represents reply failed
 var ErrReplyFailure = errors.New("reply failure")
 
+// ReplyError is an error with the reply code sent to client
+type ReplyError struct {
+	Code ReplyCode
+	Err  error
+}
+
+func (e *ReplyError) Error() string {
+	if e.Err == nil {
+		return e.Code.String()
+	}
+	return e.Code.String() + " : " + e.Err.Error()
+}
+
+func (e *ReplyError) Unwrap() error {
+	return e.Err
+}
+
 func (code ReplyCode) String() string {
 	switch code {
 	case ReplySucceed:
@@ -100,7 +117,12 @@ func (rep *Reply) send(w io.Writer) (err error) {
 	return rep.Bnd.send(w)
 }
 
-func getReplyCode(msg string) ReplyCode {
+func getReplyCode(err error) ReplyCode {
+	var re *ReplyError
+	if errors.As(err, &re) {
+		return re.Code
+	}
+	msg := err.Error()
 	if strings.Contains(msg, "refused") {
 		return ReplyConnectionRefused
 	}
@@ -109,3 +131,12 @@ func getReplyCode(msg string) ReplyCode {
 	}
 	return ReplyHostUnreachable
 }
+
+// parseReplyCode parses a failure code, e.g. "2" or "0x02"
+func parseReplyCode(s string) (ReplyCode, error) {
+	code, err := strconv.ParseUint(s, 0, 8)
+	if err != nil || ReplyCode(code) == ReplySucceed {
+		return 0, fmt.Errorf("invalid reply code : %s", s)
+	}
+	return ReplyCode(code), nil
+}
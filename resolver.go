@@ -0,0 +1,329 @@
+package socks5
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Resolver resolves the domain names of requests
+type Resolver interface {
+	LookupIP(ctx context.Context, host string) ([]net.IP, error)
+}
+
+// Various errors
+var (
+	ErrNoSuchHost = errors.New("no such host")
+)
+
+// Default values of CachingResolver
+const (
+	DefaultResolverTimeout = 5 * time.Second
+	DefaultTTL             = time.Minute
+	DefaultNegativeTTL     = 30 * time.Second
+	DefaultMaxTTL          = time.Hour
+	DefaultMaxEntries      = 10000
+)
+
+// CachingResolver is a Resolver which caches answers for their TTL,
+// and caches failures for the SOA minimum TTL.
+type CachingResolver struct {
+	// Servers are "host:port" of DNS servers tried in order,
+	// the system resolver is used if empty.
+	Servers []string
+
+	// Network is "udp" or "tcp", default "udp".
+	// Truncated UDP answers are retried over TCP.
+	Network string
+
+	// PreferIPv6 sorts IPv6 addresses first, otherwise IPv4 first
+	PreferIPv6 bool
+
+	// Zero value uses the default
+	Timeout     time.Duration
+	DefaultTTL  time.Duration // for the system resolver
+	NegativeTTL time.Duration // if no SOA is given
+	MaxTTL      time.Duration
+	MaxEntries  int // random entries are evicted over it
+
+	mu        sync.Mutex
+	cache     map[string]*resolverEntry
+	lastPrune time.Time
+}
+
+type resolverEntry struct {
+	ips     []net.IP
+	err     error
+	expires time.Time
+}
+
+// NewCachingResolver creates a CachingResolver querying the servers
+func NewCachingResolver(servers ...string) *CachingResolver {
+	return &CachingResolver{Servers: servers}
+}
+
+// LookupIP returns the cached answer, or resolves the host
+func (r *CachingResolver) LookupIP(ctx context.Context, host string) ([]net.IP, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}, nil
+	}
+	key := strings.ToLower(strings.TrimSuffix(host, "."))
+	now := time.Now()
+
+	r.mu.Lock()
+	e, ok := r.cache[key]
+	if ok && now.After(e.expires) {
+		delete(r.cache, key)
+		ok = false
+	}
+	r.mu.Unlock()
+	if ok {
+		return e.ips, e.err
+	}
+
+	var ips []net.IP
+	var ttl time.Duration
+	var err error
+	if len(r.Servers) == 0 {
+		ips, ttl, err = r.lookupSystem(ctx, key)
+	} else {
+		ips, ttl, err = r.lookupServers(ctx, key)
+	}
+	r.sort(ips)
+	if ttl <= 0 {
+		return ips, err
+	}
+	if max := r.maxTTL(); ttl > max {
+		ttl = max
+	}
+
+	r.mu.Lock()
+	if r.cache == nil {
+		r.cache = make(map[string]*resolverEntry)
+	}
+	r.prune(now)
+	r.cache[key] = &resolverEntry{ips: ips, err: err, expires: now.Add(ttl)}
+	r.mu.Unlock()
+	return ips, err
+}
+
+// Flush drops all cached answers
+func (r *CachingResolver) Flush() {
+	r.mu.Lock()
+	r.cache = nil
+	r.mu.Unlock()
+}
+
+// prune removes the expired entries at most once per DefaultTTL,
+// and random entries to make room for one
+func (r *CachingResolver) prune(now time.Time) {
+	if now.Sub(r.lastPrune) >= DefaultTTL {
+		r.lastPrune = now
+		for key, e := range r.cache {
+			if now.After(e.expires) {
+				delete(r.cache, key)
+			}
+		}
+	}
+	max := r.maxEntries()
+	for key := range r.cache {
+		if len(r.cache) < max {
+			break
+		}
+		delete(r.cache, key)
+	}
+}
+
+func (r *CachingResolver) sort(ips []net.IP) {
+	v6 := func(ip net.IP) bool { return ip.To4() == nil }
+	sorted := make([]net.IP, 0, len(ips))
+	for _, ip := range ips {
+		if v6(ip) == r.PreferIPv6 {
+			sorted = append(sorted, ip)
+		}
+	}
+	for _, ip := range ips {
+		if v6(ip) != r.PreferIPv6 {
+			sorted = append(sorted, ip)
+		}
+	}
+	copy(ips, sorted)
+}
+
+func (r *CachingResolver) notFound(host string) error {
+	return &ReplyError{Code: ReplyHostUnreachable,
+		Err: fmt.Errorf("%w : %s", ErrNoSuchHost, host)}
+}
+
+func (r *CachingResolver) lookupSystem(ctx context.Context, host string) (
+	[]net.IP, time.Duration, error) {
+	ips, err := systemResolver{}.LookupIP(ctx, host)
+	if err != nil {
+		var re *ReplyError
+		if errors.As(err, &re) {
+			return nil, r.negativeTTL(0), r.notFound(host)
+		}
+		return nil, 0, err
+	}
+	ttl := r.DefaultTTL
+	if ttl == 0 {
+		ttl = DefaultTTL
+	}
+	return ips, ttl, nil
+}
+
+func (r *CachingResolver) lookupServers(ctx context.Context, host string) (
+	[]net.IP, time.Duration, error) {
+	type result struct {
+		a   *dnsAnswer
+		err error
+	}
+	ch := make(chan result, 2)
+	for _, qtype := range []uint16{dnsTypeA, dnsTypeAAAA} {
+		go func(qtype uint16) {
+			a, err := r.query(ctx, host, qtype)
+			ch <- result{a, err}
+		}(qtype)
+	}
+
+	var ips []net.IP
+	var ttl, negTTL uint32
+	var err error
+	answered, nxdomain := 0, false
+	for i := 0; i < 2; i++ {
+		res := <-ch
+		if res.err != nil {
+			err = res.err
+			continue
+		}
+		answered++
+		switch res.a.rcode {
+		case dnsRcodeSuccess:
+		case dnsRcodeNXDomain:
+			nxdomain = true
+		default:
+			err = fmt.Errorf("DNS server failure : rcode %d", res.a.rcode)
+			answered--
+			continue
+		}
+		if len(res.a.ips) > 0 {
+			if len(ips) == 0 || res.a.ttl < ttl {
+				ttl = res.a.ttl
+			}
+			ips = append(ips, res.a.ips...)
+		} else if res.a.negTTL > negTTL {
+			negTTL = res.a.negTTL
+		}
+	}
+	if len(ips) > 0 {
+		return ips, time.Duration(ttl) * time.Second, nil
+	}
+	if answered == 2 || nxdomain {
+		return nil, r.negativeTTL(negTTL), r.notFound(host)
+	}
+	return nil, 0, err
+}
+
+func (r *CachingResolver) negativeTTL(soa uint32) time.Duration {
+	if soa > 0 {
+		return time.Duration(soa) * time.Second
+	}
+	if r.NegativeTTL > 0 {
+		return r.NegativeTTL
+	}
+	return DefaultNegativeTTL
+}
+
+func (r *CachingResolver) maxTTL() time.Duration {
+	if r.MaxTTL > 0 {
+		return r.MaxTTL
+	}
+	return DefaultMaxTTL
+}
+
+func (r *CachingResolver) maxEntries() int {
+	if r.MaxEntries > 0 {
+		return r.MaxEntries
+	}
+	return DefaultMaxEntries
+}
+
+func (r *CachingResolver) query(ctx context.Context, host string, qtype uint16) (
+	a *dnsAnswer, err error) {
+	network := r.Network
+	if network == "" {
+		network = "udp"
+	}
+	for _, server := range r.Servers {
+		a, err = r.exchange(ctx, network, server, host, qtype)
+		if err == nil && a.truncated && network == "udp" {
+			a, err = r.exchange(ctx, "tcp", server, host, qtype)
+		}
+		if err == nil {
+			return
+		}
+	}
+	return
+}
+
+func (r *CachingResolver) exchange(ctx context.Context,
+	network, server, host string, qtype uint16) (*dnsAnswer, error) {
+	id := []byte{0, 0}
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	msg, err := newDNSQuery(binary.BigEndian.Uint16(id), host, qtype)
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := r.Timeout
+	if timeout == 0 {
+		timeout = DefaultResolverTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, network, server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	if network == "tcp" {
+		msg = append([]byte{byte(len(msg) >> 8), byte(len(msg))}, msg...)
+	}
+	if _, err := conn.Write(msg); err != nil {
+		return nil, err
+	}
+
+	var buf []byte
+	if network == "tcp" {
+		l := []byte{0, 0}
+		if _, err := io.ReadFull(conn, l); err != nil {
+			return nil, err
+		}
+		buf = make([]byte, binary.BigEndian.Uint16(l))
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			return nil, err
+		}
+	} else {
+		buf = make([]byte, 1232)
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		buf = buf[:n]
+	}
+	return parseDNSAnswer(buf, binary.BigEndian.Uint16(id))
+}
@@ -0,0 +1,232 @@
+package socks5
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testDNSServer answers over UDP and TCP on the same port
+type testDNSServer struct {
+	records map[string][]net.IP
+	queries int32
+	udp     net.PacketConn
+	tcp     net.Listener
+}
+
+func newTestDNSServer(records map[string][]net.IP) (*testDNSServer, error) {
+	s := &testDNSServer{records: records}
+	var err error
+	s.tcp, err = net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		return nil, err
+	}
+	s.udp, err = net.ListenPacket("udp", s.tcp.Addr().String())
+	if err != nil {
+		s.tcp.Close()
+		return nil, err
+	}
+	go s.serveUDP()
+	go s.serveTCP()
+	return s, nil
+}
+
+func (s *testDNSServer) Addr() string {
+	return s.tcp.Addr().String()
+}
+
+func (s *testDNSServer) Close() {
+	s.udp.Close()
+	s.tcp.Close()
+}
+
+func (s *testDNSServer) serveUDP() {
+	buf := make([]byte, 512)
+	for {
+		n, addr, err := s.udp.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		s.udp.WriteTo(s.answer(buf[:n], true), addr)
+	}
+}
+
+func (s *testDNSServer) serveTCP() {
+	for {
+		conn, err := s.tcp.Accept()
+		if err != nil {
+			return
+		}
+		l := []byte{0, 0}
+		if _, err := io.ReadFull(conn, l); err == nil {
+			q := make([]byte, binary.BigEndian.Uint16(l))
+			if _, err := io.ReadFull(conn, q); err == nil {
+				resp := s.answer(q, false)
+				conn.Write(append([]byte{byte(len(resp) >> 8), byte(len(resp))}, resp...))
+			}
+		}
+		conn.Close()
+	}
+}
+
+func (s *testDNSServer) answer(q []byte, udp bool) []byte {
+	atomic.AddInt32(&s.queries, 1)
+	end, _ := skipDNSName(q, 12)
+	var labels []string
+	for off := 12; q[off] != 0; off += 1 + int(q[off]) {
+		labels = append(labels, string(q[off+1:off+1+int(q[off])]))
+	}
+	name := strings.Join(labels, ".")
+	qtype := binary.BigEndian.Uint16(q[end:])
+
+	resp := append([]byte(nil), q[:end+4]...)
+	binary.BigEndian.PutUint16(resp[2:], 0x8180)
+	ips, ok := s.records[name]
+	if !ok {
+		// NXDOMAIN with SOA, minimum 5
+		resp[3] |= dnsRcodeNXDomain
+		binary.BigEndian.PutUint16(resp[8:], 1)
+		resp = append(resp, 0xc0, 12, 0, 6, 0, 1, 0, 0, 0, 60, 0, 22)
+		resp = append(resp, 0, 0)
+		resp = append(resp, 0, 0, 0, 1, 0, 0, 0, 1, 0, 0, 0, 1, 0, 0, 0, 1, 0, 0, 0, 5)
+		return resp
+	}
+	if udp && strings.HasPrefix(name, "big.") {
+		resp[2] |= 0x02
+		return resp
+	}
+	var an uint16
+	for _, ip := range ips {
+		rr := []byte{0xc0, 12, 0, 0, 0, 1, 0, 0, 0, 60, 0, 0}
+		if ip4 := ip.To4(); ip4 != nil && qtype == dnsTypeA {
+			ip = ip4
+		} else if ip4 == nil && qtype == dnsTypeAAAA {
+			binary.BigEndian.PutUint32(rr[6:], 30)
+		} else {
+			continue
+		}
+		if strings.HasPrefix(name, "zero.") {
+			binary.BigEndian.PutUint32(rr[6:], 0)
+		}
+		binary.BigEndian.PutUint16(rr[2:], qtype)
+		binary.BigEndian.PutUint16(rr[10:], uint16(len(ip)))
+		resp = append(resp, rr...)
+		resp = append(resp, ip...)
+		an++
+	}
+	binary.BigEndian.PutUint16(resp[6:], an)
+	return resp
+}
+
+func TestCachingResolver(t *testing.T) {
+	dns, err := newTestDNSServer(map[string][]net.IP{
+		"a.test":    {net.ParseIP("192.0.2.1"), net.ParseIP("2001:db8::1")},
+		"big.test":  {net.ParseIP("192.0.2.2")},
+		"zero.test": {net.ParseIP("192.0.2.3"), net.ParseIP("2001:db8::3")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dns.Close()
+
+	ctx := context.Background()
+	r := NewCachingResolver(dns.Addr())
+	r.PreferIPv6 = true
+	ips, err := r.LookupIP(ctx, "A.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ips) != 2 || !ips[0].Equal(net.ParseIP("2001:db8::1")) {
+		t.Fatal(ips)
+	}
+	if _, err := r.LookupIP(ctx, "a.test."); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&dns.queries); n != 2 {
+		t.Fatalf("queries %d != 2", n)
+	}
+	if e := r.cache["a.test"]; time.Until(e.expires) > 30*time.Second {
+		t.Fatal("TTL is not the minimum")
+	}
+
+	// TTL 0 is not cached but still ordered
+	ips, err = r.LookupIP(ctx, "zero.test")
+	if err != nil || len(ips) != 2 || !ips[0].Equal(net.ParseIP("2001:db8::3")) {
+		t.Fatal(ips, err)
+	}
+	if _, ok := r.cache["zero.test"]; ok {
+		t.Fatal("TTL 0 is cached")
+	}
+
+	// truncated over UDP, retried over TCP
+	ips, err = r.LookupIP(ctx, "big.test")
+	if err != nil || len(ips) != 1 || !ips[0].Equal(net.ParseIP("192.0.2.2")) {
+		t.Fatal(ips, err)
+	}
+
+	// negative caching
+	atomic.StoreInt32(&dns.queries, 0)
+	for i := 0; i < 2; i++ {
+		_, err = r.LookupIP(ctx, "none.test")
+		if !errors.Is(err, ErrNoSuchHost) || getReplyCode(err) != ReplyHostUnreachable {
+			t.Fatal(err)
+		}
+	}
+	if n := atomic.LoadInt32(&dns.queries); n != 2 {
+		t.Fatalf("queries %d != 2", n)
+	}
+	if e := r.cache["none.test"]; time.Until(e.expires) > 5*time.Second {
+		t.Fatal("negative TTL is not the SOA minimum")
+	}
+
+	// bounded cache
+	r.MaxEntries = 2
+	r.cache = map[string]*resolverEntry{"old.test": {expires: time.Now().Add(-time.Second)}}
+	r.lastPrune = time.Time{}
+	for _, host := range []string{"a.test", "big.test", "none.test"} {
+		r.LookupIP(ctx, host)
+		if _, ok := r.cache["old.test"]; ok || len(r.cache) > 2 {
+			t.Fatal(len(r.cache))
+		}
+	}
+
+	// TCP only
+	r = &CachingResolver{Servers: []string{dns.Addr()}, Network: "tcp"}
+	if _, err := r.LookupIP(ctx, "a.test"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServerResolver(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+
+	dns, err := newTestDNSServer(map[string][]net.IP{
+		"local.test": {net.ParseIP("127.0.0.1")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dns.Close()
+
+	s := NewServer()
+	s.Resolver = NewCachingResolver(dns.Addr())
+	event, err := testHandshake(s, "local.test:"+port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	event.Target.Close()
+	if event.ResolveTime <= 0 {
+		t.Fatal("ResolveTime is not set")
+	}
+}
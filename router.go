@@ -0,0 +1,240 @@
+package socks5
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Outbound handles the requests selected by a Route
+type Outbound interface {
+	HandleRequest(ctx context.Context, auth *Authentication, req *Request) (*Reply, io.ReadWriteCloser, error)
+}
+
+type direct struct{}
+
+func (direct) HandleRequest(ctx context.Context, auth *Authentication, req *Request) (
+	*Reply, io.ReadWriteCloser, error) {
+	return HandleRequest(ctx, auth, req)
+}
+
+// Direct connects to the destination from this host
+var Direct Outbound = direct{}
+
+// Reject refuses the request with the reply code
+type Reject ReplyCode
+
+// HandleRequest returns a reply with the reject code
+func (r Reject) HandleRequest(ctx context.Context, auth *Authentication, req *Request) (
+	*Reply, io.ReadWriteCloser, error) {
+	reply, err := newReply(ReplyCode(r), "0.0.0.0:0")
+	if err != nil {
+		return nil, nil, err
+	}
+	return reply, nil, fmt.Errorf("%w : %s", ErrReplyFailure, ReplyCode(r).String())
+}
+
+// Route sends the requests matching all conditions to the outbound
+type Route struct {
+	Conditions []Condition
+	Outbound   Outbound
+}
+
+// Router selects an outbound for each request.
+// The first matching route wins, Default is used if none matches.
+type Router struct {
+	Routes    []*Route
+	Default   Outbound
+	Upstreams map[string]*Upstream
+	Dialers   map[string]*Dialer
+}
+
+// NewRouter creates a Router sending everything direct
+func NewRouter() *Router {
+	return &Router{
+		Default:   Direct,
+		Upstreams: make(map[string]*Upstream),
+		Dialers:   make(map[string]*Dialer),
+	}
+}
+
+// LoadRouter reads the rules from a file, see ParseRouter
+func LoadRouter(filename string) (*Router, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseRouter(f)
+}
+
+// ParseRouter reads the rules, one per line.
+//
+//	# upstream <name> socks5|http <host:port> [username password]
+//	upstream corp socks5 10.0.0.1:1080 user password
+//	upstream web http 10.0.0.2:3128
+//
+//	# dialer <name> [option]...
+//	dialer vpn mark=0x10 device=tun0 keepalive=30s user-timeout=10s fastopen
+//
+//	# route <outbound> <condition>...
+//	route corp suffix:corp.example.com
+//	route web port:80 cmd:connect
+//	route reject:0x02 cidr:10.0.0.0/8
+//
+//	# default <outbound>
+//	default direct
+//
+// Outbound is "direct", "reject[:code]" or the name of an upstream or dialer.
+// Upstreams and dialers must be declared before use.
+// The options of dialer are mark, device, keepalive, user-timeout,
+// fallback-delay, fastopen and guard, see Dialer.
+func ParseRouter(r io.Reader) (*Router, error) {
+	router := NewRouter()
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		if err := router.parseLine(strings.Fields(line)); err != nil {
+			return nil, fmt.Errorf("line %d : %w", n, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return router, nil
+}
+
+func (r *Router) parseLine(fields []string) error {
+	switch fields[0] {
+	case "upstream":
+		if len(fields) != 4 && len(fields) != 6 {
+			return fmt.Errorf("invalid upstream : %s", strings.Join(fields, " "))
+		}
+		u := &Upstream{Name: fields[1], Type: fields[2], Address: fields[3]}
+		if u.Type != "socks5" && u.Type != "http" {
+			return fmt.Errorf("unknown upstream type : %s", u.Type)
+		}
+		if len(fields) == 6 {
+			u.Username, u.Password = fields[4], fields[5]
+		}
+		r.Upstreams[u.Name] = u
+	case "dialer":
+		if len(fields) < 2 {
+			return fmt.Errorf("dialer without name")
+		}
+		d, err := parseDialer(fields[2:])
+		if err != nil {
+			return err
+		}
+		r.Dialers[fields[1]] = d
+	case "route":
+		if len(fields) < 2 {
+			return fmt.Errorf("route without outbound")
+		}
+		out, err := r.outbound(fields[1])
+		if err != nil {
+			return err
+		}
+		conds, err := parseConditions(fields[2:])
+		if err != nil {
+			return err
+		}
+		r.Routes = append(r.Routes, &Route{Conditions: conds, Outbound: out})
+	case "default":
+		if len(fields) != 2 {
+			return fmt.Errorf("invalid default : %s", strings.Join(fields, " "))
+		}
+		out, err := r.outbound(fields[1])
+		if err != nil {
+			return err
+		}
+		r.Default = out
+	default:
+		return fmt.Errorf("unknown directive : %s", fields[0])
+	}
+	return nil
+}
+
+func (r *Router) outbound(name string) (Outbound, error) {
+	switch {
+	case name == "direct":
+		return Direct, nil
+	case name == "reject":
+		return Reject(ReplyConnectionNotAllowed), nil
+	case strings.HasPrefix(name, "reject:"):
+		code, err := parseReplyCode(name[len("reject:"):])
+		if err != nil {
+			return nil, err
+		}
+		return Reject(code), nil
+	}
+	if u, ok := r.Upstreams[name]; ok {
+		return u, nil
+	}
+	if d, ok := r.Dialers[name]; ok {
+		return d, nil
+	}
+	return nil, fmt.Errorf("unknown outbound : %s", name)
+}
+
+func parseDialer(options []string) (*Dialer, error) {
+	d := &Dialer{}
+	for _, o := range options {
+		key, value := o, ""
+		if i := strings.IndexByte(o, '='); i >= 0 {
+			key, value = o[:i], o[i+1:]
+		}
+		var err error
+		switch key {
+		case "mark":
+			var mark uint64
+			mark, err = strconv.ParseUint(value, 0, 32)
+			d.Mark = int(mark)
+		case "device":
+			d.Device = value
+		case "keepalive":
+			d.KeepAlive, err = time.ParseDuration(value)
+		case "user-timeout":
+			d.UserTimeout, err = time.ParseDuration(value)
+		case "fallback-delay":
+			d.FallbackDelay, err = time.ParseDuration(value)
+		case "fastopen":
+			d.FastOpen = true
+		case "guard":
+			d.Guard = NewGuard()
+		default:
+			return nil, fmt.Errorf("unknown dialer option : %s", o)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid dialer option : %s", o)
+		}
+	}
+	return d, nil
+}
+
+// Match returns the outbound for the request
+func (r *Router) Match(ctx context.Context, auth *Authentication, req *Request) Outbound {
+	for _, route := range r.Routes {
+		if matchAll(ctx, route.Conditions, auth, req) {
+			return route.Outbound
+		}
+	}
+	if r.Default != nil {
+		return r.Default
+	}
+	return Direct
+}
+
+// HandleRequest can be used as Server.HandleRequest
+func (r *Router) HandleRequest(ctx context.Context, auth *Authentication, req *Request) (
+	*Reply, io.ReadWriteCloser, error) {
+	return r.Match(ctx, auth, req).HandleRequest(ctx, auth, req)
+}
@@ -0,0 +1,190 @@
+package socks5
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRouterMatch(t *testing.T) {
+	rules := `
+# comment
+upstream corp socks5 10.0.0.1:1080 user password
+upstream web http 10.0.0.2:3128
+dialer vpn mark=0x10 device=tun0 keepalive=30s user-timeout=10s fastopen guard
+
+route vpn port:8080
+route corp suffix:corp.example.com
+route web wildcard:*.web.* port:80-90
+route reject:0x04 regex:^ads[0-9]+\.
+route reject cidr:10.0.0.0/8 cmd:connect
+default direct
+`
+	r, err := ParseRouter(strings.NewReader(rules))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	t1 := map[string]Outbound{
+		"corp.example.com:443":  r.Upstreams["corp"],
+		"a.CORP.example.com:22": r.Upstreams["corp"],
+		"xcorp.example.com:22":  Direct,
+		"www.web.org:80":        r.Upstreams["web"],
+		"www.web.org:443":       Direct,
+		"ads12.example.com:80":  Reject(ReplyHostUnreachable),
+		"10.1.2.3:80":           Reject(ReplyConnectionNotAllowed),
+		"192.0.2.1:80":          Direct,
+		"192.0.2.1:8080":        r.Dialers["vpn"],
+	}
+	for addr, want := range t1 {
+		req, err := newRequest("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := r.Match(ctx, nil, req); got != want {
+			t.Fatalf("%s : %v != %v", addr, got, want)
+		}
+	}
+
+	if d := r.Dialers["vpn"]; d.Mark != 0x10 || d.Device != "tun0" ||
+		d.UserTimeout != 10*time.Second || !d.FastOpen || d.Guard == nil {
+		t.Fatal("Error")
+	}
+
+	t2 := []string{
+		"route nowhere port:80",
+		"route direct port:abc",
+		"route direct unknown:1",
+		"route reject:0 port:80",
+		"upstream x ftp 1.2.3.4:21",
+		"dialer x mark=abc",
+		"dialer x nodelay",
+		"proxy x",
+	}
+	for _, i := range t2 {
+		if _, err := ParseRouter(strings.NewReader(i)); err == nil {
+			t.Fatal(i)
+		}
+	}
+}
+
+func TestRouterUpstream(t *testing.T) {
+	// Target
+	l1, err := net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l1.Close()
+	target := l1.Addr().String()
+
+	// SOCKS5 upstream
+	l2, err := net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l2.Close()
+	go NewServerWithAuth("user", "password").Serve(l2)
+
+	// HTTP upstream
+	l3, err := net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l3.Close()
+	go serveHTTPConnect(l3)
+
+	r := NewRouter()
+	r.Routes = []*Route{
+		{
+			Conditions: []Condition{MatchCommand(CmdConnect)},
+			Outbound: &Upstream{Type: "socks5", Address: l2.Addr().String(),
+				Username: "user", Password: "password"},
+		},
+	}
+	if err := testRoute(r, target); err != nil {
+		t.Fatal(err)
+	}
+	r.Routes[0].Outbound = &Upstream{Type: "http", Address: l3.Addr().String()}
+	if err := testRoute(r, target); err != nil {
+		t.Fatal(err)
+	}
+	r.Routes[0].Outbound = Reject(ReplyConnectionNotAllowed)
+	if err := testRoute(r, target); err == nil {
+		t.Fatal("Error")
+	}
+}
+
+func testRoute(r *Router, target string) error {
+	req, err := newRequest("tcp", target)
+	if err != nil {
+		return err
+	}
+	reply, conn, err := r.HandleRequest(context.Background(), nil, req)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if reply.Code != ReplySucceed {
+		return ErrReplyFailure
+	}
+	return nil
+}
+
+func serveHTTPConnect(l net.Listener) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			req, err := http.ReadRequest(bufio.NewReader(conn))
+			if err != nil || req.Method != http.MethodConnect {
+				return
+			}
+			target, err := net.Dial("tcp", req.Host)
+			if err != nil {
+				io.WriteString(conn, "HTTP/1.1 502 Bad Gateway\r\n\r\n")
+				return
+			}
+			defer target.Close()
+			io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+			Pipe(context.Background(), conn, target)
+		}()
+	}
+}
+
+func TestUpstreamStalled(t *testing.T) {
+	// accepts and never answers
+	l, err := net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	req, _ := newRequest("tcp", "192.0.2.1:80")
+	for _, typ := range []string{"socks5", "http"} {
+		u := &Upstream{Type: typ, Address: l.Addr().String()}
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		start := time.Now()
+		_, target, err := u.HandleRequest(ctx, nil, req)
+		cancel()
+		if err == nil || target != nil || time.Since(start) > time.Second {
+			t.Fatal(typ, err, time.Since(start))
+		}
+	}
+}
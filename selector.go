@@ -0,0 +1,143 @@
+package socks5
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// MethodRule offers the methods, in order of preference,
+// to the clients of the networks. No method refuses the clients.
+type MethodRule struct {
+	Methods  []Method
+	Networks []*net.IPNet
+}
+
+// MethodSelector is a Server.SelectMethod deciding by the client IP.
+// The first rule matching the client wins, Default applies to the others
+// and to clients of unknown IP. The first method of the rule offered by
+// the client is selected, otherwise MethodNoAcceptable.
+type MethodSelector struct {
+	Rules   []*MethodRule
+	Default []Method
+}
+
+// NewMethodSelector creates a MethodSelector requiring username/password
+func NewMethodSelector() *MethodSelector {
+	return &MethodSelector{Default: []Method{MethodUsernamePassword}}
+}
+
+// LoadMethodSelector reads the rules from a file, see ParseMethodSelector
+func LoadMethodSelector(filename string) (*MethodSelector, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseMethodSelector(f)
+}
+
+// ParseMethodSelector reads the rules, one per line.
+// Methods are none, userpass, reject or a number.
+//
+//	# <method>[,<method>...] <cidr>...
+//	none,userpass 10.0.0.0/8 192.168.1.0/24
+//	reject 203.0.113.0/24
+//	default userpass
+func ParseMethodSelector(r io.Reader) (*MethodSelector, error) {
+	s := NewMethodSelector()
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("line %d : invalid rule : %s", n, line)
+		}
+		if fields[0] == "default" {
+			if len(fields) != 2 {
+				return nil, fmt.Errorf("line %d : invalid rule : %s", n, line)
+			}
+			methods, err := parseMethods(fields[1])
+			if err != nil {
+				return nil, fmt.Errorf("line %d : %w", n, err)
+			}
+			s.Default = methods
+			continue
+		}
+		rule := &MethodRule{}
+		methods, err := parseMethods(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("line %d : %w", n, err)
+		}
+		rule.Methods = methods
+		for _, f := range fields[1:] {
+			ipnet, err := parseCIDR(f)
+			if err != nil {
+				return nil, fmt.Errorf("line %d : %w", n, err)
+			}
+			rule.Networks = append(rule.Networks, ipnet)
+		}
+		s.Rules = append(s.Rules, rule)
+	}
+	return s, scanner.Err()
+}
+
+func parseMethods(s string) ([]Method, error) {
+	if s == "reject" {
+		return nil, nil
+	}
+	var methods []Method
+	for _, name := range strings.Split(s, ",") {
+		switch name {
+		case "none":
+			methods = append(methods, MethodNotRequired)
+		case "userpass":
+			methods = append(methods, MethodUsernamePassword)
+		default:
+			v, err := strconv.ParseUint(name, 0, 8)
+			if err != nil || Method(v) == MethodNoAcceptable {
+				return nil, fmt.Errorf("invalid method : %s", name)
+			}
+			methods = append(methods, Method(v))
+		}
+	}
+	return methods, nil
+}
+
+// Methods returns the methods offered to the client IP
+func (s *MethodSelector) Methods(ip net.IP) []Method {
+	if ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			ip = ip4
+		}
+		for _, r := range s.Rules {
+			for _, n := range r.Networks {
+				if n.Contains(ip) {
+					return r.Methods
+				}
+			}
+		}
+	}
+	return s.Default
+}
+
+// SelectMethod is a Server.SelectMethod
+func (s *MethodSelector) SelectMethod(ctx context.Context, methods []Method) Method {
+	for _, want := range s.Methods(ClientIP(ctx)) {
+		for _, m := range methods {
+			if m == want {
+				return m
+			}
+		}
+	}
+	getConnInfo(ctx).metrics().Add("method_refused", 1)
+	return MethodNoAcceptable
+}
@@ -0,0 +1,55 @@
+package socks5
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestMethodSelector(t *testing.T) {
+	rules := `
+# comment
+reject 10.9.0.0/16
+none,userpass 10.0.0.0/8 192.168.1.1
+0x80 2001:db8::/32
+default userpass
+`
+	s, err := ParseMethodSelector(strings.NewReader(rules))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := func(ip string) context.Context {
+		return context.WithValue(context.Background(), connInfoKey,
+			&connInfo{remote: &net.TCPAddr{IP: net.ParseIP(ip), Port: 1}})
+	}
+	both := []Method{MethodUsernamePassword, MethodNotRequired}
+	t1 := []struct {
+		ip      string
+		methods []Method
+		want    Method
+	}{
+		{"10.1.2.3", both, MethodNotRequired},
+		{"10.1.2.3", []Method{MethodUsernamePassword}, MethodUsernamePassword},
+		{"192.168.1.1", both, MethodNotRequired},
+		{"10.9.1.1", both, MethodNoAcceptable},
+		{"192.0.2.1", both, MethodUsernamePassword},
+		{"192.0.2.1", []Method{MethodNotRequired}, MethodNoAcceptable},
+		{"2001:db8::1", []Method{0x80, MethodNotRequired}, 0x80},
+	}
+	for _, c := range t1 {
+		if got := s.SelectMethod(client(c.ip), c.methods); got != c.want {
+			t.Fatal(c.ip, c.methods, got)
+		}
+	}
+	if got := s.SelectMethod(context.Background(), both); got != MethodUsernamePassword {
+		t.Fatal(got)
+	}
+
+	t2 := []string{"none", "ftp 10.0.0.0/8", "none 10.0.0.0/33", "0xff 10.0.0.0/8", "default none 1.2.3.4"}
+	for _, i := range t2 {
+		if _, err := ParseMethodSelector(strings.NewReader(i)); err == nil {
+			t.Fatal(i)
+		}
+	}
+}
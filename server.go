@@ -2,9 +2,14 @@ package socks5
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"log"
 	"net"
+	"os"
+	"sync"
+	"time"
 )
 
 // Stage respresent stage of handle process
@@ -26,6 +31,16 @@ type Event struct {
 	Req    *Request
 	Reply  *Reply
 	Target io.ReadWriteCloser
+
+	// ResolveTime is the time spent resolving Req.Dst
+	ResolveTime time.Duration
+
+	// Dialed is the remote address won by Dialer
+	Dialed net.Addr
+
+	// Conn carries the request and the relay,
+	// the connection or its encapsulation by MethodAEAD or Method
+	Conn io.ReadWriter
 }
 
 // Server defines parameters for running an SOCKS5 server
@@ -36,8 +51,93 @@ type Server struct {
 	// return false, handshake will be abort
 	Authenticate func(ctx context.Context, auth *Authentication) bool
 
+	// HMACSecret returns the secret of the username for MethodHMAC,
+	// which replaces username/password for the clients offering it.
+	// nil disables MethodHMAC.
+	HMACSecret func(ctx context.Context, username string) ([]byte, bool)
+
+	// UsernameParams strips the parameters of usernames before
+	// Authenticate, nil disables them
+	UsernameParams *UsernameParams
+
+	// Isolation gives each set of credentials its own egress identity,
+	// nil disables it
+	Isolation *Isolation
+
+	// PSK is the pre-shared key of MethodAEAD, which encapsulates the
+	// connection for the clients offering it, the selected method runs
+	// inside. It must be a random key of MinPSKLen bytes at least,
+	// nil disables MethodAEAD.
+	PSK []byte
+
+	// Compress accepts MethodDeflate in place of no authentication
+	// from the clients offering it
+	Compress bool
+
+	// return ReplySucceed to continue with HandleRequest,
+	// other codes are sent to client and the request is refused.
+	Permit func(ctx context.Context, auth *Authentication, req *Request) ReplyCode
+
 	// if err != nil, target should be nil
 	HandleRequest func(ctx context.Context, auth *Authentication, req *Request) (*Reply, io.ReadWriteCloser, error)
+
+	// Dialer is used by the default HandleRequest,
+	// UserDialers overrides it for the authenticated usernames.
+	Dialer      *Dialer
+	UserDialers map[string]*Dialer
+
+	// Resolver resolves the domain names for Dialer,
+	// the system resolver is used if nil.
+	Resolver Resolver
+
+	// Logger logs connection errors and events, nil disables logging
+	Logger *log.Logger
+
+	// Metrics counts events of the server, nil disables metrics
+	Metrics *Metrics
+
+	// Bandwidth limits the relay, nil is unlimited
+	Bandwidth *Bandwidth
+
+	// PeerCred authenticates the clients of unix sockets by the UID,
+	// GID and PID of their process, Linux only
+	PeerCred bool
+
+	// UnixMode and UnixOwner ("user:group") set the permissions
+	// of unix sockets of ListenAndServe, empty values keep the defaults
+	UnixMode  os.FileMode
+	UnixOwner string
+
+	// CertAuth authenticates the clients of verified TLS certificates,
+	// nil ignores the certificates
+	CertAuth *CertAuth
+
+	// AuthThrottle slows down and locks out authentication failures,
+	// nil disables it
+	AuthThrottle *AuthThrottle
+
+	// Quota accounts the relay of authenticated users, nil disables it
+	Quota *Quota
+
+	// Limits of concurrent connections, zero is unlimited.
+	// Connections over MaxConns, MaxConnsPerIP or MaxHandshakes are closed
+	// before the greeting, requests over MaxConnsPerUser or MaxConnsPerHost
+	// are refused with ReplyConnectionNotAllowed.
+	MaxConns        int
+	MaxConnsPerIP   int
+	MaxConnsPerUser int
+	MaxConnsPerHost int
+	MaxHandshakes   int
+
+	// HandshakeTimeout bounds the handshake until the reply of the request,
+	// the resolution and the dial included, zero is unlimited
+	HandshakeTimeout time.Duration
+
+	mu       sync.Mutex
+	sessions map[uint64]*Session
+	lastID   uint64
+	slots    map[string]int
+	nonces   nonceCache
 }
 
 // NewServer creates a new SOCKS5 proxy Server
@@ -50,8 +150,12 @@ func NewServerWithAuth(username, password string) *Server {
 	return &Server{
 		SelectMethod: SelectMethodUserPass,
 		Authenticate: func(ctx context.Context, auth *Authentication) bool {
-			return auth != nil && string(auth.Username) == username &&
-				string(auth.Password) == password
+			if auth == nil {
+				return false
+			}
+			u := secureCompare(auth.Username, []byte(username))
+			p := secureCompare(auth.Password, []byte(password))
+			return u && p
 		},
 	}
 }
@@ -64,9 +168,16 @@ func NewHandShaker() *Server {
 	}
 }
 
-// ListenAndServe listens on the network address and then calls Serve
+// ListenAndServe listens on the network address and then calls Serve,
+// "unix:/path" is a unix socket.
 func (s *Server) ListenAndServe(address string) error {
-	l, err := net.Listen("tcp", address)
+	var l net.Listener
+	var err error
+	if path, ok := unixPath(address); ok {
+		l, err = s.listenUnix(path)
+	} else {
+		l, err = net.Listen("tcp", address)
+	}
 	if err != nil {
 		return err
 	}
@@ -82,8 +193,9 @@ func (s *Server) Serve(l net.Listener) error {
 			return err
 		}
 		go func() {
-			s.ServeConn(context.Background(), conn)
-			//log.Println(err)
+			if err := s.ServeConn(context.Background(), conn); err != nil {
+				s.logf("%s : %v", conn.RemoteAddr(), err)
+			}
 		}()
 	}
 }
@@ -91,20 +203,55 @@ func (s *Server) Serve(l net.Listener) error {
 // ServeConn accepts a connection and handle SOCKS5 request
 func (s *Server) ServeConn(ctx context.Context, conn io.ReadWriteCloser) error {
 	defer conn.Close()
-	event, err := s.Handshake(ctx, conn)
+	ctx = withConnInfo(ctx, conn)
+	info := getConnInfo(ctx)
+	defer info.releaseAll()
+	if !s.acquireConn(info) {
+		return ErrConnLimit
+	}
+	releaseHandshake, ok := s.acquireSlot("handshakes", "", s.MaxHandshakes)
+	if !ok {
+		return ErrConnLimit
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	hctx, hcancel := ctx, context.CancelFunc(func() {})
+	nc, ok := conn.(net.Conn)
+	if s.HandshakeTimeout > 0 {
+		hctx, hcancel = context.WithTimeout(ctx, s.HandshakeTimeout)
+		if ok {
+			nc.SetDeadline(time.Now().Add(s.HandshakeTimeout))
+		}
+	}
+	event, err := s.Handshake(hctx, conn)
+	hcancel()
+	releaseHandshake()
+	if ok && s.HandshakeTimeout > 0 {
+		nc.SetDeadline(time.Time{})
+	}
 	if err != nil {
 		return err
 	}
 	// Start Proxy
 	if event.Target != nil {
 		defer event.Target.Close()
-		return Pipe(ctx, conn, event.Target)
+		sess := s.addSession(ctx, cancel, &event)
+		defer s.removeSession(sess)
+		return Pipe(ctx, s.relayConn(ctx, sess, event.Conn), event.Target)
 	}
 	return nil
 }
 
 // Handshake accepts a connection and handle SOCKS5 handshake
 func (s *Server) Handshake(ctx context.Context, conn io.ReadWriter) (event Event, err error) {
+	// the slots of a bare handshake are released on return,
+	// ServeConn keeps them until the relay ends
+	if _, ok := ctx.Value(connInfoKey).(*connInfo); !ok {
+		ctx = withConnInfo(ctx, conn)
+		defer getConnInfo(ctx).releaseAll()
+	}
+	info := getConnInfo(ctx)
+	info.server = s
 	isDone := func() bool {
 		select {
 		case <-ctx.Done():
@@ -118,17 +265,46 @@ func (s *Server) Handshake(ctx context.Context, conn io.ReadWriter) (event Event
 
 	// Select method
 	event.Stage = StageSelectMethod
+	event.Conn = conn
+	var connAuth *Authentication
+	var requirePassword bool
+	connAuth, requirePassword, err = s.connAuth(conn)
+	if err != nil {
+		return
+	}
 	var methods []Method
 	methods, err = readMethods(conn)
 	if err != nil {
 		return
 	}
-	if s.SelectMethod != nil {
+	switch {
+	case requirePassword:
+		event.Method = SelectMethodUserPass(ctx, methods)
+	case connAuth != nil && SelectMethodNoRequired(ctx, methods) == MethodNotRequired:
+		event.Method = MethodNotRequired
+	case s.SelectMethod != nil:
 		event.Method = s.SelectMethod(ctx, methods)
-	} else {
+	default:
 		event.Method = SelectMethodNoRequired(ctx, methods)
 	}
-	err = sendMethodSelection(conn, event.Method)
+	if event.Method == MethodUsernamePassword && s.HMACSecret != nil && hasMethod(methods, MethodHMAC) {
+		event.Method = MethodHMAC
+	}
+	// the selected method runs inside MethodAEAD
+	sealed := event.Method != MethodNoAcceptable && s.PSK != nil && hasMethod(methods, MethodAEAD)
+	if sealed {
+		if err = checkPSK(s.PSK); err != nil {
+			return
+		}
+	}
+	if event.Method == MethodNotRequired && !sealed && s.Compress && hasMethod(methods, MethodDeflate) {
+		event.Method = MethodDeflate
+	}
+	if sealed {
+		err = sendMethodSelection(conn, MethodAEAD)
+	} else {
+		err = sendMethodSelection(conn, event.Method)
+	}
 	if err != nil {
 		return
 	}
@@ -138,16 +314,67 @@ func (s *Server) Handshake(ctx context.Context, conn io.ReadWriter) (event Event
 
 	// Authenticate
 	event.Stage = StageAuth
+	if sealed {
+		conn, err = acceptAEAD(conn, s.PSK)
+		if err != nil {
+			if errors.Is(err, ErrAuthFailed) {
+				s.Metrics.Add("auth_failed", 1)
+			}
+			return
+		}
+		event.Conn = conn
+		err = sendMethodSelection(conn, event.Method)
+		if err != nil {
+			return
+		}
+	}
 	switch event.Method {
 	case MethodNotRequired:
-	case MethodUsernamePassword:
-		event.Auth, err = readAuth(conn)
+		event.Auth = connAuth
+	case MethodDeflate:
+		conn = newDeflateConn(conn, s.Metrics)
+		event.Auth = connAuth
+		event.Conn = conn
+	case MethodUsernamePassword, MethodHMAC:
+		verify := func() bool {
+			return s.Authenticate != nil && s.Authenticate(ctx, event.Auth)
+		}
+		if event.Method == MethodHMAC {
+			event.Auth, verify, err = s.readHMACAuth(ctx, conn)
+		} else {
+			event.Auth, err = readAuth(conn)
+		}
 		if err != nil {
 			return
 		}
+		if err = s.parseParams(event.Auth); err != nil {
+			s.Metrics.Add("params_invalid", 1)
+			sendAuthInvalidParams(conn)
+			return
+		}
 		result := false
-		if s.Authenticate != nil {
-			result = s.Authenticate(ctx, event.Auth)
+		if s.AuthThrottle.Locked(ctx, event.Auth) {
+			s.Metrics.Add("auth_locked", 1)
+		} else {
+			result = verify()
+		}
+		if result && requirePassword {
+			result = string(connAuth.Username) == string(event.Auth.Username)
+			event.Auth.Attributes = mergeAttributes(event.Auth.Attributes, connAuth.Attributes)
+		}
+		if result {
+			s.AuthThrottle.Succeed(ctx, event.Auth)
+		} else {
+			s.Metrics.Add("auth_failed", 1)
+			delay, locked := s.AuthThrottle.Fail(ctx, event.Auth)
+			for _, key := range locked {
+				s.Metrics.Add("auth_lockout", 1)
+				s.logf("%s : authentication locked out %s", info.remote, key)
+			}
+			if !sleep(ctx, delay) {
+				err = ctx.Err()
+				return
+			}
 		}
 		err = sendAuthStatus(conn, result)
 		if err != nil {
@@ -167,15 +394,41 @@ func (s *Server) Handshake(ctx context.Context, conn io.ReadWriter) (event Event
 
 	// Handle request
 	event.Stage = StageHandleRequest
+	info.auth = event.Auth
+	info.setParams(event.Auth)
+	info.dialer = s.dialer(event.Auth)
+	info.resolver = s.Resolver
 	event.Req, err = readRequest(conn)
 	if err != nil {
 		return
 	}
+	if s.Permit != nil {
+		if code := s.Permit(ctx, event.Auth, event.Req); code != ReplySucceed {
+			err = refuse(conn, &event, code)
+			return
+		}
+	}
+	if s.Quota != nil && event.Auth != nil && s.Quota.Exhausted(string(event.Auth.Username)) {
+		s.Metrics.Add("quota_refused", 1)
+		err = refuse(conn, &event, ReplyConnectionNotAllowed)
+		return
+	}
+	if !s.acquireRequest(info, &event) {
+		err = refuse(conn, &event, ReplyConnectionNotAllowed)
+		return
+	}
+	if s.Isolation != nil && event.Auth != nil {
+		var release func()
+		info.egress, release = s.Isolation.acquire(event.Auth, s.Metrics)
+		info.release = append(info.release, release)
+	}
 	if s.HandleRequest != nil {
 		event.Reply, event.Target, err = s.HandleRequest(ctx, event.Auth, event.Req)
 	} else {
 		event.Reply, event.Target, err = HandleRequest(ctx, event.Auth, event.Req)
 	}
+	event.ResolveTime = info.resolveTime
+	event.Dialed = info.dialed
 	if isDone() {
 		return
 	}
@@ -202,12 +455,64 @@ func (s *Server) Handshake(ctx context.Context, conn io.ReadWriter) (event Event
 		err = fmt.Errorf("Reply : %s", event.Reply.Code.String())
 		return
 	}
+	if event.Dialed != nil {
+		s.logf("%s connected to %s via %s, bnd %s", info.remote,
+			event.Req.Dst.String(), event.Dialed, event.Reply.Bnd.String())
+	}
 	if isDone() {
 		return
 	}
 	return
 }
 
+// connAuth returns the identity of the connection itself,
+// the TLS client certificate or the unix socket peer
+func (s *Server) connAuth(conn io.ReadWriter) (auth *Authentication, requirePassword bool, err error) {
+	if auth, err = s.certAuth(conn); auth != nil || err != nil {
+		return auth, auth != nil && s.CertAuth.RequirePassword, err
+	}
+	auth, err = s.peerAuth(conn)
+	return auth, false, err
+}
+
+func (s *Server) logf(format string, v ...interface{}) {
+	if s != nil && s.Logger != nil {
+		s.Logger.Printf(format, v...)
+	}
+}
+
+// sleep waits for d, it returns false if ctx is done first
+func sleep(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return true
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (s *Server) dialer(auth *Authentication) *Dialer {
+	if auth != nil {
+		if d, ok := s.UserDialers[string(auth.Username)]; ok {
+			return d
+		}
+	}
+	return s.Dialer
+}
+
+// refuse sends a failure reply without handling the request
+func refuse(w io.Writer, event *Event, code ReplyCode) error {
+	event.Stage = StageReply
+	event.Reply, _ = newReply(code, "0.0.0.0:0")
+	event.Reply.send(w)
+	return fmt.Errorf("%w : %s", ErrReplyFailure, code.String())
+}
+
 // SelectMethodNoRequired is the default value of Server.SelectMethod
 func SelectMethodNoRequired(ctx context.Context, methods []Method) Method {
 	for _, m := range methods {
@@ -235,32 +540,9 @@ func HandleRequestSkip(ctx context.Context, auth *Authentication, req *Request)
 	return reply, nil, err
 }
 
-// HandleRequest is the default value of Server.HandleRequest
+// HandleRequest is the default value of Server.HandleRequest,
+// it handles the request with the Dialer chosen by the Server.
 func HandleRequest(ctx context.Context, auth *Authentication, req *Request) (
 	*Reply, io.ReadWriteCloser, error) {
-	switch req.Cmd {
-	case CmdConnect:
-		return handleConnect(req.Dst.String())
-	case CmdBind:
-	case CmdUDP:
-	default:
-	}
-	return nil, nil, ErrCmdUnsupported
-}
-
-func handleConnect(addr string) (
-	reply *Reply, target io.ReadWriteCloser, err error) {
-	var conn net.Conn
-	conn, err = net.Dial("tcp", addr)
-	if err != nil {
-		reply, _ = newReply(getReplyCode(err.Error()), "0.0.0.0:0")
-		return
-	}
-	reply, err = newReply(ReplySucceed, conn.LocalAddr().String())
-	if err != nil {
-		conn.Close()
-		return
-	}
-	target = conn
-	return
+	return dialerFromContext(ctx).HandleRequest(ctx, auth, req)
 }
@@ -0,0 +1,121 @@
+package socks5
+
+import (
+	"context"
+	"io"
+	"net"
+	"sort"
+	"time"
+)
+
+// Session is an active relay of the Server
+type Session struct {
+	ID     uint64
+	Client net.Addr
+	Auth   *Authentication
+	Req    *Request
+	Start  time.Time
+
+	// Upload and Download limit this session only,
+	// they can be changed at runtime with SetLimit.
+	Upload   *Limiter
+	Download *Limiter
+
+	cancel context.CancelFunc
+}
+
+// Close stops the relay of the session
+func (sess *Session) Close() {
+	sess.cancel()
+}
+
+// Sessions returns the active sessions ordered by ID
+func (s *Server) Sessions() []*Session {
+	s.mu.Lock()
+	list := make([]*Session, 0, len(s.sessions))
+	for _, sess := range s.sessions {
+		list = append(list, sess)
+	}
+	s.mu.Unlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+func (s *Server) addSession(ctx context.Context, cancel context.CancelFunc,
+	event *Event) *Session {
+	sess := &Session{
+		Client: ClientAddr(ctx),
+		Auth:   event.Auth,
+		Req:    event.Req,
+		Start:  time.Now(),
+		cancel: cancel,
+	}
+	if b := s.Bandwidth; b != nil {
+		sess.Upload = NewLimiter(b.ConnUpload.Bytes, b.ConnUpload.Burst)
+		sess.Download = NewLimiter(b.ConnDownload.Bytes, b.ConnDownload.Burst)
+	} else {
+		sess.Upload, sess.Download = NewLimiter(0, 0), NewLimiter(0, 0)
+	}
+
+	s.mu.Lock()
+	if s.sessions == nil {
+		s.sessions = make(map[uint64]*Session)
+	}
+	s.lastID++
+	sess.ID = s.lastID
+	s.sessions[sess.ID] = sess
+	s.mu.Unlock()
+	return sess
+}
+
+// closeUserSessions closes the sessions of the user
+func (s *Server) closeUserSessions(username string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, sess := range s.sessions {
+		if sess.Auth != nil && string(sess.Auth.Username) == username {
+			sess.Close()
+		}
+	}
+}
+
+func (s *Server) removeSession(sess *Session) {
+	s.mu.Lock()
+	delete(s.sessions, sess.ID)
+	s.mu.Unlock()
+}
+
+// relayConn wraps the client connection with the limiters
+// and the accounting of the session
+func (s *Server) relayConn(ctx context.Context, sess *Session,
+	conn io.ReadWriter) io.ReadWriter {
+	var r io.Reader = conn
+	var w io.Writer = conn
+	if q := s.Quota; q != nil && sess.Auth != nil {
+		username := string(sess.Auth.Username)
+		count := func(n int) {
+			if q.Add(username, int64(n)) && q.CutActive {
+				s.closeUserSessions(username)
+			}
+		}
+		r = &countingReader{r: r, count: count}
+		w = &countingWriter{w: w, count: count}
+	}
+
+	up := []*Limiter{sess.Upload}
+	down := []*Limiter{sess.Download}
+	if b := s.Bandwidth; b != nil {
+		if sess.Auth != nil {
+			u, d := b.User(string(sess.Auth.Username))
+			up, down = append(up, u), append(down, d)
+		}
+		up, down = append(up, b.Upload), append(down, b.Download)
+	}
+	return &struct {
+		io.Reader
+		io.Writer
+	}{
+		NewLimitedReader(ctx, r, up...),
+		NewLimitedWriter(ctx, w, down...),
+	}
+}
@@ -0,0 +1,73 @@
+//go:build linux
+// +build linux
+
+package socks5
+
+import (
+	"syscall"
+	"time"
+)
+
+// missing in package syscall
+const (
+	tcpUserTimeout     = 0x12
+	tcpFastOpenConnect = 0x1e
+)
+
+func freebind(network, address string, c syscall.RawConn) error {
+	var err error
+	if e := c.Control(func(fd uintptr) {
+		err = syscall.SetsockoptInt(int(fd), syscall.SOL_IP, syscall.IP_FREEBIND, 1)
+	}); e != nil {
+		return e
+	}
+	return err
+}
+
+func setSockopts(c syscall.RawConn, mark int, device string,
+	userTimeout time.Duration, fastOpen bool) error {
+	var err error
+	if e := c.Control(func(fd uintptr) {
+		s := int(fd)
+		if mark != 0 {
+			if err = syscall.SetsockoptInt(s, syscall.SOL_SOCKET, syscall.SO_MARK, mark); err != nil {
+				return
+			}
+		}
+		if device != "" {
+			if err = syscall.BindToDevice(s, device); err != nil {
+				return
+			}
+		}
+		if userTimeout > 0 {
+			ms := int(userTimeout / time.Millisecond)
+			if err = syscall.SetsockoptInt(s, syscall.IPPROTO_TCP, tcpUserTimeout, ms); err != nil {
+				return
+			}
+		}
+		if fastOpen {
+			err = syscall.SetsockoptInt(s, syscall.IPPROTO_TCP, tcpFastOpenConnect, 1)
+			// kernels without TFO connect normally
+			if err == syscall.ENOPROTOOPT || err == syscall.EINVAL {
+				err = nil
+			}
+		}
+	}); e != nil {
+		return e
+	}
+	return err
+}
+
+func peerCred(c syscall.RawConn) (*PeerCred, error) {
+	var cred *syscall.Ucred
+	var err error
+	if e := c.Control(func(fd uintptr) {
+		cred, err = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	}); e != nil {
+		return nil, e
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &PeerCred{UID: int(cred.Uid), GID: int(cred.Gid), PID: int(cred.Pid)}, nil
+}
@@ -0,0 +1,47 @@
+//go:build linux
+// +build linux
+
+package socks5
+
+import (
+	"context"
+	"errors"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestSockopts(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	var mark, timeout int
+	d := &Dialer{
+		Mark:        0x10,
+		Device:      "lo",
+		UserTimeout: 1500 * time.Millisecond,
+		KeepAlive:   30 * time.Second,
+		FastOpen:    true,
+		Control: func(network, address string, c syscall.RawConn) error {
+			return c.Control(func(fd uintptr) {
+				mark, _ = syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_MARK)
+				timeout, _ = syscall.GetsockoptInt(int(fd), syscall.IPPROTO_TCP, tcpUserTimeout)
+			})
+		},
+	}
+	conn, err := d.DialContext(context.Background(), "tcp", l.Addr().String())
+	if errors.Is(err, syscall.EPERM) {
+		t.Skip(err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if mark != 0x10 || timeout != 1500 {
+		t.Fatal(mark, timeout)
+	}
+}
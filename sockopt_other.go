@@ -0,0 +1,25 @@
+//go:build !linux
+// +build !linux
+
+package socks5
+
+import (
+	"errors"
+	"syscall"
+	"time"
+)
+
+var errSockoptUnsupported = errors.New("socket option is only supported on Linux")
+
+func freebind(network, address string, c syscall.RawConn) error {
+	return errSockoptUnsupported
+}
+
+func setSockopts(c syscall.RawConn, mark int, device string,
+	userTimeout time.Duration, fastOpen bool) error {
+	return errSockoptUnsupported
+}
+
+func peerCred(c syscall.RawConn) (*PeerCred, error) {
+	return nil, errSockoptUnsupported
+}
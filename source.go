@@ -0,0 +1,191 @@
+package socks5
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"sync"
+	"time"
+)
+
+// SourceStrategy selects the source address of outbound connections
+type SourceStrategy int
+
+// Various strategies
+const (
+	SourceRoundRobin SourceStrategy = iota
+	SourceRandom
+	SourceHashUser // the same user always gets the same address
+	SourceSticky   // the same session keeps its address for StickyTTL
+)
+
+// DefaultStickyTTL is the default value of SourcePool.StickyTTL
+const DefaultStickyTTL = 10 * time.Minute
+
+// SourcePool is the local addresses outbound connections are bound to.
+// The address is selected from the same family as the destination,
+// no source is bound if the pool has none of the family.
+type SourcePool struct {
+	IPs []net.IP
+
+	// Prefixes yield a random address within the prefix,
+	// the socket is bound with IP_FREEBIND (Linux only).
+	// It's meant for a routed IPv6 prefix, e.g. a /64.
+	Prefixes []*net.IPNet
+
+	Strategy  SourceStrategy
+	StickyTTL time.Duration
+
+	mu     sync.Mutex
+	next   int
+	sticky map[string]*stickySource
+}
+
+type stickySource struct {
+	ip      net.IP
+	expires time.Time
+}
+
+// sourceCandidate is an IP, or a prefix if net is not nil
+type sourceCandidate struct {
+	ip  net.IP
+	net *net.IPNet
+}
+
+// Select returns the source address for the destination IP,
+// freebind reports the address must be bound with IP_FREEBIND.
+func (p *SourcePool) Select(ctx context.Context, dst net.IP) (src net.IP, freebind bool) {
+	src, freebind, _ = p.selectSource(ctx, dst)
+	return
+}
+
+// selectSource is Select of a nil able pool, it fails if the connection
+// is isolated and no address is left for its identity
+func (p *SourcePool) selectSource(ctx context.Context, dst net.IP) (net.IP, bool, error) {
+	v4 := dst.To4() != nil
+	var candidates []sourceCandidate
+	if p != nil {
+		for _, ip := range p.IPs {
+			if (ip.To4() != nil) == v4 {
+				candidates = append(candidates, sourceCandidate{ip: ip})
+			}
+		}
+		for _, n := range p.Prefixes {
+			if (n.IP.To4() != nil) == v4 {
+				candidates = append(candidates, sourceCandidate{net: n})
+			}
+		}
+	}
+	info := getConnInfo(ctx)
+	if info.egress != nil {
+		src, free := info.egress.source(candidates)
+		if src == nil {
+			info.metrics().Add("isolation_exhausted", 1)
+			return nil, false, &ReplyError{Code: ReplyConnectionNotAllowed,
+				Err: fmt.Errorf("%w : egress %d", ErrIsolation, info.egress.ID)}
+		}
+		return src, free, nil
+	}
+	if len(candidates) == 0 {
+		return nil, false, nil
+	}
+	src, free := p.selectStrategy(info, candidates)
+	return src, free, nil
+}
+
+func (p *SourcePool) selectStrategy(info *connInfo, candidates []sourceCandidate) (net.IP, bool) {
+	switch p.Strategy {
+	case SourceRandom:
+		return p.pick(candidates, randomUint32())
+	case SourceHashUser:
+		user := ""
+		if info.auth != nil {
+			user = string(info.auth.Username)
+		}
+		h := fnv.New32a()
+		h.Write([]byte(user))
+		sum := h.Sum32()
+		c := candidates[sum%uint32(len(candidates))]
+		if c.net == nil {
+			return c.ip, false
+		}
+		return randomIPInNet(c.net, h.Sum(nil)), true
+	case SourceSticky:
+		return p.selectSticky(info.sessionKey(), info.sessionTTL, candidates)
+	}
+	p.mu.Lock()
+	i := p.next
+	p.next++
+	p.mu.Unlock()
+	return p.pick(candidates, uint32(i))
+}
+
+func (p *SourcePool) pick(candidates []sourceCandidate, n uint32) (net.IP, bool) {
+	c := candidates[n%uint32(len(candidates))]
+	if c.net == nil {
+		return c.ip, false
+	}
+	return randomIPInNet(c.net, nil), true
+}
+
+func (p *SourcePool) selectSticky(key string, ttl time.Duration, candidates []sourceCandidate) (net.IP, bool) {
+	if ttl == 0 {
+		ttl = p.StickyTTL
+	}
+	if ttl == 0 {
+		ttl = DefaultStickyTTL
+	}
+	now := time.Now()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.sticky == nil {
+		p.sticky = make(map[string]*stickySource)
+	}
+	for k, s := range p.sticky {
+		if now.After(s.expires) {
+			delete(p.sticky, k)
+		}
+	}
+	if s, ok := p.sticky[key]; ok {
+		for _, c := range candidates {
+			if c.net == nil && c.ip.Equal(s.ip) {
+				s.expires = now.Add(ttl)
+				return s.ip, false
+			}
+			if c.net != nil && c.net.Contains(s.ip) {
+				s.expires = now.Add(ttl)
+				return s.ip, true
+			}
+		}
+	}
+	ip, freebind := p.pick(candidates, uint32(p.next))
+	p.next++
+	p.sticky[key] = &stickySource{ip: ip, expires: now.Add(ttl)}
+	return ip, freebind
+}
+
+// randomIPInNet returns an address in n, the host bits are
+// taken from seed if given, otherwise random
+func randomIPInNet(n *net.IPNet, seed []byte) net.IP {
+	ip := make(net.IP, len(n.IP))
+	host := make([]byte, len(ip))
+	if len(seed) > 0 {
+		for i := range host {
+			host[i] = seed[i%len(seed)] ^ byte(i*31)
+		}
+	} else {
+		rand.Read(host)
+	}
+	for i := range ip {
+		ip[i] = n.IP[i]&n.Mask[i] | host[i]&^n.Mask[i]
+	}
+	return ip
+}
+
+func randomUint32() uint32 {
+	b := []byte{0, 0, 0, 0}
+	rand.Read(b)
+	return uint32(b[0])<<24 | uint32(b[1])<<16 | uint32(b[2])<<8 | uint32(b[3])
+}
@@ -0,0 +1,74 @@
+package socks5
+
+import (
+	"context"
+	"net"
+	"testing"
+)
+
+func TestSourcePool(t *testing.T) {
+	_, prefix, _ := net.ParseCIDR("2001:db8:1:2::/64")
+	p := &SourcePool{
+		IPs:      []net.IP{net.ParseIP("192.0.2.1"), net.ParseIP("192.0.2.2")},
+		Prefixes: []*net.IPNet{prefix},
+	}
+	ctx := context.Background()
+	v4, v6 := net.ParseIP("198.51.100.1"), net.ParseIP("2001:db8::1")
+
+	// round robin
+	a, _ := p.Select(ctx, v4)
+	b, _ := p.Select(ctx, v4)
+	if a.Equal(b) {
+		t.Fatal(a, b)
+	}
+	ip, free := p.Select(ctx, v6)
+	if !free || !prefix.Contains(ip) {
+		t.Fatal(ip)
+	}
+	if ip, _ := (&SourcePool{IPs: p.IPs}).Select(ctx, v6); ip != nil {
+		t.Fatal(ip)
+	}
+
+	// hash of username
+	p.Strategy = SourceHashUser
+	ctx = withConnInfo(context.Background(), nil)
+	getConnInfo(ctx).auth, _ = newAuth("alice", "")
+	a, _ = p.Select(ctx, v6)
+	b, _ = p.Select(ctx, v6)
+	if !a.Equal(b) || !prefix.Contains(a) {
+		t.Fatal(a, b)
+	}
+
+	// sticky per session
+	p.Strategy = SourceSticky
+	getConnInfo(ctx).session = "abc"
+	a, _ = p.Select(ctx, v4)
+	for i := 0; i < 3; i++ {
+		if b, _ = p.Select(ctx, v4); !a.Equal(b) {
+			t.Fatal(a, b)
+		}
+	}
+	getConnInfo(ctx).session = "def"
+	if b, _ = p.Select(ctx, v4); a.Equal(b) {
+		t.Fatal(a, b)
+	}
+}
+
+func TestSourceBind(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	s := NewServer()
+	s.Dialer = &Dialer{Source: &SourcePool{IPs: []net.IP{net.ParseIP("127.0.0.2")}}}
+	event, err := testHandshake(s, l.Addr().String())
+	if err != nil {
+		t.Skip(err)
+	}
+	event.Target.Close()
+	if !event.Reply.Bnd.IP.Equal(net.ParseIP("127.0.0.2")) {
+		t.Fatal(event.Reply.Bnd.String())
+	}
+}
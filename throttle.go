@@ -0,0 +1,209 @@
+package socks5
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"net"
+	"sync"
+	"time"
+)
+
+// Default values of AuthThrottle
+const (
+	DefaultAuthMaxFailures = 5
+	DefaultAuthWindow      = 10 * time.Minute
+	DefaultAuthLockout     = 15 * time.Minute
+	DefaultAuthDelay       = 100 * time.Millisecond
+	DefaultAuthMaxDelay    = 5 * time.Second
+)
+
+// AuthThrottle slows down and locks out repeated authentication failures.
+// Failures are tracked by client IP and by username, each failure doubles
+// the delay before the failure status is sent, and MaxFailures within Window
+// locks out the IP or the username for Lockout. Zero values use the defaults.
+type AuthThrottle struct {
+	MaxFailures int
+	Window      time.Duration
+	Lockout     time.Duration
+	Delay       time.Duration
+	MaxDelay    time.Duration
+
+	// Allow are the client networks never throttled
+	Allow []*net.IPNet
+
+	mu        sync.Mutex
+	failures  map[string]*authFailure
+	lastPrune time.Time
+}
+
+type authFailure struct {
+	count  int
+	first  time.Time
+	locked time.Time // until
+}
+
+// AllowCIDR adds a client network never throttled
+func (t *AuthThrottle) AllowCIDR(cidr string) error {
+	n, err := parseCIDR(cidr)
+	if err != nil {
+		return err
+	}
+	t.Allow = append(t.Allow, n)
+	return nil
+}
+
+func (t *AuthThrottle) keys(ctx context.Context, auth *Authentication) []string {
+	var keys []string
+	if ip := ClientIP(ctx); ip != nil {
+		for _, n := range t.Allow {
+			if n.Contains(ip) {
+				return nil
+			}
+		}
+		keys = append(keys, "ip:"+ip.String())
+	}
+	if auth != nil {
+		keys = append(keys, "user:"+string(auth.Username))
+	}
+	return keys
+}
+
+func (t *AuthThrottle) get(key string, now time.Time) *authFailure {
+	f, ok := t.failures[key]
+	if !ok {
+		return nil
+	}
+	if now.Before(f.locked) {
+		return f
+	}
+	if !f.locked.IsZero() || now.Sub(f.first) > t.window() {
+		delete(t.failures, key)
+		return nil
+	}
+	return f
+}
+
+// Locked reports whether the client IP or the username is locked out
+func (t *AuthThrottle) Locked(ctx context.Context, auth *Authentication) bool {
+	if t == nil {
+		return false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	for _, key := range t.keys(ctx, auth) {
+		if f := t.get(key, now); f != nil && now.Before(f.locked) {
+			return true
+		}
+	}
+	return false
+}
+
+// Fail records a failure, it returns the delay before the failure status
+// and the keys ("ip:..." or "user:...") locked out by this failure.
+func (t *AuthThrottle) Fail(ctx context.Context, auth *Authentication) (delay time.Duration, locked []string) {
+	if t == nil {
+		return 0, nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	t.prune(now)
+	count := 0
+	for _, key := range t.keys(ctx, auth) {
+		f := t.get(key, now)
+		if f == nil {
+			if t.failures == nil {
+				t.failures = make(map[string]*authFailure)
+			}
+			f = &authFailure{first: now}
+			t.failures[key] = f
+		}
+		f.count++
+		if f.count == t.maxFailures() {
+			f.locked = now.Add(t.lockout())
+			locked = append(locked, key)
+		}
+		if f.count > count {
+			count = f.count
+		}
+	}
+	if count == 0 {
+		return 0, nil
+	}
+	delay = t.delay()
+	for i := 1; i < count && delay < t.maxDelay(); i++ {
+		delay *= 2
+	}
+	if delay > t.maxDelay() {
+		delay = t.maxDelay()
+	}
+	return delay, locked
+}
+
+// Succeed forgets the failures of the username,
+// those of the client IP expire with Window.
+func (t *AuthThrottle) Succeed(ctx context.Context, auth *Authentication) {
+	if t == nil || auth == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	key := "user:" + string(auth.Username)
+	if f := t.get(key, time.Now()); f != nil && f.locked.IsZero() {
+		delete(t.failures, key)
+	}
+}
+
+// prune removes the expired failures at most once per Window
+func (t *AuthThrottle) prune(now time.Time) {
+	if now.Sub(t.lastPrune) < t.window() {
+		return
+	}
+	t.lastPrune = now
+	for key := range t.failures {
+		t.get(key, now)
+	}
+}
+
+func (t *AuthThrottle) maxFailures() int {
+	if t.MaxFailures > 0 {
+		return t.MaxFailures
+	}
+	return DefaultAuthMaxFailures
+}
+
+func (t *AuthThrottle) window() time.Duration {
+	if t.Window > 0 {
+		return t.Window
+	}
+	return DefaultAuthWindow
+}
+
+func (t *AuthThrottle) lockout() time.Duration {
+	if t.Lockout > 0 {
+		return t.Lockout
+	}
+	return DefaultAuthLockout
+}
+
+func (t *AuthThrottle) delay() time.Duration {
+	if t.Delay > 0 {
+		return t.Delay
+	}
+	return DefaultAuthDelay
+}
+
+func (t *AuthThrottle) maxDelay() time.Duration {
+	if t.MaxDelay > 0 {
+		return t.MaxDelay
+	}
+	return DefaultAuthMaxDelay
+}
+
+// secureCompare compares in constant time, the length included
+func secureCompare(a, b []byte) bool {
+	ha, hb := sha256.Sum256(a), sha256.Sum256(b)
+	return subtle.ConstantTimeCompare(ha[:], hb[:]) == 1
+}
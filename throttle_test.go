@@ -0,0 +1,81 @@
+package socks5
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestAuthThrottle(t *testing.T) {
+	th := &AuthThrottle{MaxFailures: 3, Delay: time.Second, MaxDelay: 3 * time.Second}
+	if err := th.AllowCIDR("10.0.0.0/8"); err != nil {
+		t.Fatal(err)
+	}
+	client := func(ip string) context.Context {
+		return context.WithValue(context.Background(), connInfoKey,
+			&connInfo{remote: &net.TCPAddr{IP: net.ParseIP(ip), Port: 1}})
+	}
+	alice := &Authentication{Username: []byte("alice")}
+	bob := &Authentication{Username: []byte("bob")}
+
+	// backoff of the IP and lockout of the username
+	want := []time.Duration{time.Second, 2 * time.Second, 3 * time.Second}
+	for i, w := range want {
+		delay, locked := th.Fail(client("192.0.2.1"), alice)
+		if delay != w || len(locked) != 0 && i != 2 {
+			t.Fatal(i, delay, locked)
+		}
+		if i == 2 && len(locked) != 2 {
+			t.Fatal(locked)
+		}
+	}
+	if !th.Locked(client("192.0.2.2"), alice) || !th.Locked(client("192.0.2.1"), bob) ||
+		th.Locked(client("192.0.2.2"), bob) {
+		t.Fatal("Error")
+	}
+
+	// allowlist
+	for i := 0; i < 5; i++ {
+		if delay, _ := th.Fail(client("10.1.2.3"), bob); delay != 0 {
+			t.Fatal(delay)
+		}
+	}
+	if th.Locked(client("10.1.2.3"), alice) {
+		t.Fatal("Error")
+	}
+
+	// success forgets the username
+	th.Fail(client("192.0.2.3"), bob)
+	th.Succeed(client("192.0.2.3"), bob)
+	if delay, _ := th.Fail(client("192.0.2.4"), bob); delay != time.Second {
+		t.Fatal(delay)
+	}
+}
+
+func TestAuthLockout(t *testing.T) {
+	s := NewServerWithAuth("user", "password")
+	s.Metrics = NewMetrics()
+	s.AuthThrottle = &AuthThrottle{MaxFailures: 2, Delay: time.Millisecond}
+	l, err := net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go s.Serve(l)
+	proxy := l.Addr().String()
+
+	for _, password := range []string{"wrong", "wrong", "password"} {
+		c, err := NewClientWithAuth(proxy, "user", password)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.Dial("tcp", proxy); !errors.Is(err, ErrAuthFailed) {
+			t.Fatal(err)
+		}
+	}
+	if s.Metrics.Get("auth_lockout") != 2 || s.Metrics.Get("auth_locked") != 1 {
+		t.Fatal(s.Metrics.String())
+	}
+}
@@ -0,0 +1,162 @@
+package socks5
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+)
+
+// ErrPinMismatch represents the proxy certificate matches no pinned key
+var ErrPinMismatch = errors.New("public key of proxy not pinned")
+
+// Attributes of certificate identities
+const (
+	AttributeTLSSubject     = "tls-subject"
+	AttributeTLSFingerprint = "tls-fingerprint"
+)
+
+// CertAuth derives the identity of verified TLS client certificates.
+// The client is authenticated by its certificate and MethodNotRequired,
+// or by both the certificate and username/password of the same user
+// if RequirePassword.
+type CertAuth struct {
+	// Field of the username: "cn", "dns", "email", "uri" of the
+	// first SAN of the kind, or "fingerprint" to only use Fingerprints
+	Field string
+
+	// Fingerprints maps the SHA-256 of certificates to usernames,
+	// they take precedence over Field
+	Fingerprints map[string]string
+
+	RequirePassword bool
+}
+
+// Fingerprint returns the SHA-256 of the certificate in hex
+func Fingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// SPKIHash returns the SHA-256 of the public key of the certificate,
+// the pin of Client.PinnedSPKI
+func SPKIHash(cert *x509.Certificate) []byte {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return sum[:]
+}
+
+// Identity returns the username of the certificate
+func (c *CertAuth) Identity(cert *x509.Certificate) (string, bool) {
+	fp := Fingerprint(cert)
+	for k, name := range c.Fingerprints {
+		k = strings.ToLower(strings.Replace(k, ":", "", -1))
+		if k == fp {
+			return name, true
+		}
+	}
+	var name string
+	switch c.Field {
+	case "", "cn":
+		name = cert.Subject.CommonName
+	case "dns":
+		if len(cert.DNSNames) > 0 {
+			name = cert.DNSNames[0]
+		}
+	case "email":
+		if len(cert.EmailAddresses) > 0 {
+			name = cert.EmailAddresses[0]
+		}
+	case "uri":
+		if len(cert.URIs) > 0 {
+			name = cert.URIs[0].String()
+		}
+	}
+	return name, name != ""
+}
+
+// certAuth returns the identity of the verified client certificate,
+// nil if there is none
+func (s *Server) certAuth(conn io.ReadWriter) (*Authentication, error) {
+	tc, ok := conn.(*tls.Conn)
+	if !ok || s.CertAuth == nil {
+		return nil, nil
+	}
+	if err := tc.Handshake(); err != nil {
+		return nil, err
+	}
+	state := tc.ConnectionState()
+	if len(state.VerifiedChains) == 0 {
+		return nil, nil
+	}
+	cert := state.PeerCertificates[0]
+	name, ok := s.CertAuth.Identity(cert)
+	if !ok {
+		return nil, nil
+	}
+	return &Authentication{
+		Username: []byte(name),
+		Attributes: map[string][]string{
+			AttributeTLSSubject:     {cert.Subject.String()},
+			AttributeTLSFingerprint: {Fingerprint(cert)},
+		},
+	}, nil
+}
+
+// ListenAndServeTLS listens on the network address for TLS connections
+// and then calls Serve. The client certificates are verified if
+// config.ClientAuth is tls.VerifyClientCertIfGiven or
+// tls.RequireAndVerifyClientCert, see Server.CertAuth.
+func (s *Server) ListenAndServeTLS(address string, config *tls.Config) error {
+	l, err := tls.Listen("tcp", address, config)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// dialTLS wraps the connection to the proxy in TLS with the pins
+func (c *Client) dialTLS(conn net.Conn) (net.Conn, error) {
+	config := c.TLS.Clone()
+	if config.ServerName == "" {
+		host, _, err := net.SplitHostPort(c.proxy)
+		if err != nil {
+			return nil, err
+		}
+		config.ServerName = host
+	}
+	if len(c.PinnedSPKI) > 0 {
+		verify := config.VerifyPeerCertificate
+		config.VerifyPeerCertificate = func(raw [][]byte, chains [][]*x509.Certificate) error {
+			if verify != nil {
+				if err := verify(raw, chains); err != nil {
+					return err
+				}
+			}
+			if len(raw) == 0 {
+				return ErrPinMismatch
+			}
+			cert, err := x509.ParseCertificate(raw[0])
+			if err != nil {
+				return err
+			}
+			hash := SPKIHash(cert)
+			for _, pin := range c.PinnedSPKI {
+				if bytes.Equal(pin, hash) {
+					return nil
+				}
+			}
+			return fmt.Errorf("%w : %s", ErrPinMismatch, config.ServerName)
+		}
+	}
+	tc := tls.Client(conn, config)
+	if err := tc.Handshake(); err != nil {
+		return nil, err
+	}
+	return tc, nil
+}
@@ -0,0 +1,89 @@
+package socks5
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestMutualTLS(t *testing.T) {
+	cert, pool := testCertificate(t)
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    pool,
+		ClientAuth:   tls.VerifyClientCertIfGiven,
+	}
+	l, err := tls.Listen("tcp", "127.0.0.1:", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	users := make(chan string, 1)
+	s := NewServerWithAuth("alice", "password")
+	s.SelectMethod = SelectMethodNoRequired
+	s.CertAuth = &CertAuth{Fingerprints: map[string]string{Fingerprint(cert.Leaf): "alice"}}
+	s.HandleRequest = func(ctx context.Context, auth *Authentication, req *Request) (
+		*Reply, io.ReadWriteCloser, error) {
+		name := ""
+		if auth != nil {
+			name = string(auth.Username)
+		}
+		users <- name
+		return HandleRequestSkip(ctx, auth, req)
+	}
+	go s.Serve(l)
+	proxy := l.Addr().String()
+
+	dial := func(c *Client, err error) (string, error) {
+		if err != nil {
+			return "", err
+		}
+		conn, err := c.Dial("tcp", "192.0.2.1:80")
+		if err != nil {
+			return "", err
+		}
+		conn.Close()
+		return <-users, nil
+	}
+
+	// certificate identity
+	c, err := NewClient(proxy)
+	c.TLS = &tls.Config{RootCAs: pool, Certificates: []tls.Certificate{cert}}
+	c.PinnedSPKI = [][]byte{SPKIHash(cert.Leaf)}
+	if user, err := dial(c, err); err != nil || user != "alice" {
+		t.Fatal(user, err)
+	}
+
+	// no certificate
+	c, err = NewClient(proxy)
+	c.TLS = &tls.Config{RootCAs: pool}
+	if user, err := dial(c, err); err != nil || user != "" {
+		t.Fatal(user, err)
+	}
+
+	// pin mismatch
+	c.PinnedSPKI = [][]byte{make([]byte, 32)}
+	if _, err := dial(c, nil); !errors.Is(err, ErrPinMismatch) {
+		t.Fatal(err)
+	}
+
+	// both the certificate and the password
+	s.CertAuth.RequirePassword = true
+	for _, password := range []string{"password", "wrong"} {
+		c, err = NewClientWithAuth(proxy, "alice", password)
+		c.TLS = &tls.Config{RootCAs: pool, Certificates: []tls.Certificate{cert}}
+		user, err := dial(c, err)
+		if password == "password" && (err != nil || user != "alice") ||
+			password == "wrong" && !errors.Is(err, ErrAuthFailed) {
+			t.Fatal(user, err)
+		}
+	}
+	c, err = NewClient(proxy)
+	c.TLS = &tls.Config{RootCAs: pool, Certificates: []tls.Certificate{cert}}
+	if _, err := dial(c, err); !errors.Is(err, ErrMethodNoAcceptable) {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,115 @@
+package socks5
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ErrInvalidTOTP represents a TOTP secret is not base32
+var ErrInvalidTOTP = errors.New("invalid TOTP secret")
+
+// Parameters of TOTP (RFC 6238), the defaults of authenticator apps
+const (
+	TOTPPeriod = 30 * time.Second
+	TOTPDigits = 6
+
+	// DefaultTOTPSkew is the time steps accepted before and after the current one
+	DefaultTOTPSkew = 1
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// NewTOTPSecret returns a random base32 secret of 160 bits
+func NewTOTPSecret() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(b), nil
+}
+
+func decodeTOTPSecret(secret string) ([]byte, error) {
+	key, err := totpEncoding.DecodeString(strings.TrimRight(strings.ToUpper(secret), "="))
+	if err != nil || len(key) == 0 {
+		return nil, fmt.Errorf("%w : %v", ErrInvalidTOTP, err)
+	}
+	return key, nil
+}
+
+func totpStep(t time.Time) int64 {
+	return t.Unix() / int64(TOTPPeriod/time.Second)
+}
+
+// hotp returns the code of the counter (RFC 4226)
+func hotp(key []byte, counter int64) string {
+	m := hmac.New(sha1.New, key)
+	binary.Write(m, binary.BigEndian, counter)
+	sum := m.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	code := binary.BigEndian.Uint32(sum[offset:]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", TOTPDigits, code%1000000)
+}
+
+// TOTPCode returns the code of the secret at t
+func TOTPCode(secret string, t time.Time) (string, error) {
+	key, err := decodeTOTPSecret(secret)
+	if err != nil {
+		return "", err
+	}
+	return hotp(key, totpStep(t)), nil
+}
+
+// verifyTOTP returns the time step of the code within skew steps of t
+func verifyTOTP(secret, code string, t time.Time, skew int) (int64, bool) {
+	key, err := decodeTOTPSecret(secret)
+	if err != nil {
+		return 0, false
+	}
+	step := totpStep(t)
+	found, ok := int64(0), false
+	for i := -int64(skew); i <= int64(skew); i++ {
+		if hmac.Equal([]byte(hotp(key, step+i)), []byte(code)) && !ok {
+			found, ok = step+i, true
+		}
+	}
+	return found, ok
+}
+
+// splitTOTP splits "password+123456" into the password and the code
+func splitTOTP(password string) (string, string, bool) {
+	i := strings.LastIndexByte(password, '+')
+	if i < 0 || len(password)-i-1 != TOTPDigits {
+		return password, "", false
+	}
+	for _, c := range password[i+1:] {
+		if c < '0' || c > '9' {
+			return password, "", false
+		}
+	}
+	return password[:i], password[i+1:], true
+}
+
+// TOTPURI returns the otpauth URI of the secret for authenticator apps
+func TOTPURI(issuer, account, secret string) string {
+	label := account
+	if issuer != "" {
+		label = issuer + ":" + account
+	}
+	q := url.Values{}
+	q.Set("secret", secret)
+	if issuer != "" {
+		q.Set("issuer", issuer)
+	}
+	q.Set("algorithm", "SHA1")
+	q.Set("digits", fmt.Sprint(TOTPDigits))
+	q.Set("period", fmt.Sprint(int(TOTPPeriod/time.Second)))
+	return "otpauth://totp/" + url.PathEscape(label) + "?" + q.Encode()
+}
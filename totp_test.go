@@ -0,0 +1,82 @@
+package socks5
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTOTPCode(t *testing.T) {
+	// RFC 6238 SHA1 vectors, the last 6 of 8 digits
+	secret := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+	for sec, want := range map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1234567890: "005924",
+		2000000000: "279037",
+	} {
+		if code, err := TOTPCode(secret, time.Unix(sec, 0)); err != nil || code != want {
+			t.Fatal(sec, code, err)
+		}
+	}
+	if _, err := TOTPCode("not base32!", time.Now()); err == nil {
+		t.Fatal("Error")
+	}
+
+	uri := TOTPURI("socks5", "alice", "JBSWY3DPEHPK3PXP")
+	if !strings.HasPrefix(uri, "otpauth://totp/socks5:alice?") ||
+		!strings.Contains(uri, "secret=JBSWY3DPEHPK3PXP") || !strings.Contains(uri, "issuer=socks5") {
+		t.Fatal(uri)
+	}
+
+	for password, want := range map[string][2]string{
+		"secret+123456":   {"secret", "123456"},
+		"a+b+654321":      {"a+b", "654321"},
+		"secret+12345":    {"secret+12345", ""},
+		"secret+12345x":   {"secret+12345x", ""},
+		"no-code-at-all!": {"no-code-at-all!", ""},
+	} {
+		if p, c, _ := splitTOTP(password); p != want[0] || c != want[1] {
+			t.Fatal(password, p, c)
+		}
+	}
+}
+
+func TestUserDBTOTP(t *testing.T) {
+	secret, err := NewTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	users, err := ParseUsers(strings.NewReader(
+		"alice:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g= totp=" + secret + "\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := &UserDB{users: users, TOTPSkew: DefaultTOTPSkew}
+	auth := func(password string) bool {
+		return db.Authenticate(context.Background(),
+			&Authentication{Username: []byte("alice"), Password: []byte(password)})
+	}
+	now := time.Now()
+	code, _ := TOTPCode(secret, now)
+	prev, _ := TOTPCode(secret, now.Add(-TOTPPeriod))
+	old, _ := TOTPCode(secret, now.Add(-3*TOTPPeriod))
+	if auth("password") || auth("password+"+old) || auth("wrong+"+code) {
+		t.Fatal("Error")
+	}
+	if !auth("password+" + code) {
+		t.Fatal("Error")
+	}
+	// reused and earlier codes
+	if auth("password+"+code) || prev != code && auth("password+"+prev) {
+		t.Fatal("Error")
+	}
+
+	if err := db.SetTOTP("alice", ""); err != nil || !auth("password") {
+		t.Fatal(err)
+	}
+	if db.SetTOTP("alice", "!") == nil || db.SetTOTP("bob", secret) == nil {
+		t.Fatal("Error")
+	}
+}
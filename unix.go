@@ -0,0 +1,158 @@
+package socks5
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ErrAddressInUse represents a unix socket is served by another process
+var ErrAddressInUse = errors.New("address in use")
+
+// Attributes of unix socket peers
+const (
+	AttributeUnixUID = "unix-uid"
+	AttributeUnixGID = "unix-gid"
+	AttributeUnixPID = "unix-pid"
+)
+
+// PeerCred is the credentials of the process of a unix socket peer
+type PeerCred struct {
+	UID int
+	GID int
+	PID int
+}
+
+// unixPath returns the path of a "unix:" address
+func unixPath(address string) (string, bool) {
+	if strings.HasPrefix(address, "unix:") {
+		return address[len("unix:"):], true
+	}
+	return "", false
+}
+
+// listenUnix listens on the unix socket path, removing a stale socket
+// and setting UnixMode and UnixOwner. The socket is created in a private
+// directory and renamed into place, so it is never reachable before.
+func (s *Server) listenUnix(path string) (net.Listener, error) {
+	if st, err := os.Lstat(path); err == nil {
+		if st.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%w : %s is not a socket", ErrAddressInUse, path)
+		}
+		conn, err := net.Dial("unix", path)
+		if err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("%w : %s", ErrAddressInUse, path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	dir, err := ioutil.TempDir(filepath.Dir(path), ".socks5")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	tmp := filepath.Join(dir, "s")
+	l, err := net.Listen("unix", tmp)
+	if err != nil {
+		return nil, err
+	}
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	if err := s.chownUnix(tmp); err != nil {
+		l.Close()
+		return nil, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return &unixListener{Listener: l, path: path}, nil
+}
+
+// unixListener removes the renamed socket on Close
+type unixListener struct {
+	net.Listener
+	path string
+}
+
+func (l *unixListener) Close() error {
+	err := l.Listener.Close()
+	os.Remove(l.path)
+	return err
+}
+
+func (s *Server) chownUnix(path string) error {
+	if s.UnixMode != 0 {
+		if err := os.Chmod(path, s.UnixMode); err != nil {
+			return err
+		}
+	}
+	if s.UnixOwner == "" {
+		return nil
+	}
+	uid, gid := -1, -1
+	owner, group := s.UnixOwner, ""
+	if i := strings.IndexByte(owner, ':'); i >= 0 {
+		owner, group = owner[:i], owner[i+1:]
+	}
+	if owner != "" {
+		id := owner
+		if _, err := strconv.Atoi(owner); err != nil {
+			u, err := user.Lookup(owner)
+			if err != nil {
+				return err
+			}
+			id = u.Uid
+		}
+		uid, _ = strconv.Atoi(id)
+	}
+	if group != "" {
+		id := group
+		if _, err := strconv.Atoi(group); err != nil {
+			g, err := user.LookupGroup(group)
+			if err != nil {
+				return err
+			}
+			id = g.Gid
+		}
+		gid, _ = strconv.Atoi(id)
+	}
+	return os.Lchown(path, uid, gid)
+}
+
+// peerAuth returns the identity of the peer process of a unix socket,
+// the username of the UID or the UID if it has no name.
+func (s *Server) peerAuth(conn io.ReadWriter) (*Authentication, error) {
+	uc, ok := conn.(*net.UnixConn)
+	if !ok || !s.PeerCred {
+		return nil, nil
+	}
+	rc, err := uc.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	cred, err := peerCred(rc)
+	if err != nil {
+		return nil, err
+	}
+	name := strconv.Itoa(cred.UID)
+	if u, err := user.LookupId(name); err == nil {
+		name = u.Username
+	}
+	return &Authentication{
+		Username: []byte(name),
+		Attributes: map[string][]string{
+			AttributeUnixUID: {strconv.Itoa(cred.UID)},
+			AttributeUnixGID: {strconv.Itoa(cred.GID)},
+			AttributeUnixPID: {strconv.Itoa(cred.PID)},
+		},
+	}, nil
+}
@@ -0,0 +1,80 @@
+//go:build linux
+// +build linux
+
+package socks5
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestUnixSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "socks5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "socks5.sock")
+
+	// a stale socket
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	auths := make(chan *Authentication, 1)
+	s := NewServerWithAuth("user", "password")
+	s.PeerCred = true
+	s.UnixMode = 0660
+	s.UnixOwner = strconv.Itoa(os.Getuid()) + ":" + strconv.Itoa(os.Getgid())
+	s.HandleRequest = func(ctx context.Context, auth *Authentication, req *Request) (
+		*Reply, io.ReadWriteCloser, error) {
+		auths <- auth
+		return HandleRequestSkip(ctx, auth, req)
+	}
+	l, err := s.listenUnix(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go s.Serve(l)
+
+	if st, err := os.Lstat(path); err != nil || st.Mode().Perm() != 0660 {
+		t.Fatal(st.Mode(), err)
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 1 {
+		t.Fatal(files)
+	}
+	if err := s.ListenAndServe("unix:" + path); !errors.Is(err, ErrAddressInUse) {
+		t.Fatal(err)
+	}
+
+	c, err := NewClient("unix:" + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := c.Dial("tcp", "192.0.2.1:80")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	auth := <-auths
+	if auth == nil || auth.Attributes[AttributeUnixUID][0] != strconv.Itoa(os.Getuid()) ||
+		auth.Attributes[AttributeUnixPID][0] != strconv.Itoa(os.Getpid()) {
+		t.Fatal(auth)
+	}
+
+	l.Close()
+	if _, err := os.Lstat(path); !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+}
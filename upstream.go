@@ -0,0 +1,130 @@
+package socks5
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+)
+
+// Upstream is a proxy which requests are forwarded through
+type Upstream struct {
+	Name     string
+	Type     string // "socks5" or "http"
+	Address  string
+	Username string
+	Password string
+}
+
+// HandleRequest connects to req.Dst through the upstream proxy
+func (u *Upstream) HandleRequest(ctx context.Context, auth *Authentication, req *Request) (
+	reply *Reply, target io.ReadWriteCloser, err error) {
+	if req.Cmd != CmdConnect {
+		return nil, nil, ErrCmdUnsupported
+	}
+	// only SOCKS5 upstreams authenticated with the identity are isolated
+	if getConnInfo(ctx).egress != nil && (u.Type == "http" || u.Username != "") {
+		err = &ReplyError{Code: ReplyConnectionNotAllowed,
+			Err: fmt.Errorf("%w : upstream %s", ErrIsolation, u.Name)}
+		reply, _ = newReply(ReplyConnectionNotAllowed, "0.0.0.0:0")
+		return
+	}
+	var conn net.Conn
+	switch u.Type {
+	case "socks5":
+		conn, err = u.dialSOCKS5(ctx, req.Dst.String())
+	case "http":
+		conn, err = u.dialHTTP(ctx, req.Dst.String())
+	default:
+		return nil, nil, fmt.Errorf("unknown upstream type : %s", u.Type)
+	}
+	if err != nil {
+		reply, _ = newReply(getReplyCode(err), "0.0.0.0:0")
+		return
+	}
+	reply, err = newReply(ReplySucceed, conn.LocalAddr().String())
+	if err != nil {
+		conn.Close()
+		return
+	}
+	target = conn
+	return
+}
+
+func (u *Upstream) dialSOCKS5(ctx context.Context, address string) (net.Conn, error) {
+	var c *Client
+	var err error
+	if u.Username != "" {
+		c, err = NewClientWithAuth(u.Address, u.Username, u.Password)
+	} else if e := getConnInfo(ctx).egress; e != nil {
+		username, password := e.upstreamAuth()
+		c, err = NewClientWithAuth(u.Address, username, password)
+	} else {
+		c, err = NewClient(u.Address)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return c.DialContext(ctx, "tcp", address)
+}
+
+func (u *Upstream) dialHTTP(ctx context.Context, address string) (conn net.Conn, err error) {
+	var d net.Dialer
+	conn, err = d.DialContext(ctx, "tcp", u.Address)
+	if err != nil {
+		return
+	}
+	stop := watchContext(ctx, conn)
+	defer func() {
+		stop()
+		if err != nil {
+			conn.Close()
+			if ctx.Err() != nil {
+				err = ctx.Err()
+			}
+		}
+	}()
+
+	req := "CONNECT " + address + " HTTP/1.1\r\nHost: " + address + "\r\n"
+	if u.Username != "" {
+		cred := base64.StdEncoding.EncodeToString([]byte(u.Username + ":" + u.Password))
+		req += "Proxy-Authorization: Basic " + cred + "\r\n"
+	}
+	if _, err = io.WriteString(conn, req+"\r\n"); err != nil {
+		return
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		return
+	}
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusOK:
+	case resp.StatusCode == http.StatusForbidden,
+		resp.StatusCode == http.StatusProxyAuthRequired:
+		err = &ReplyError{Code: ReplyConnectionNotAllowed,
+			Err: fmt.Errorf("%w : http %s", ErrReplyFailure, resp.Status)}
+		return
+	default:
+		err = fmt.Errorf("%w : http %s", ErrReplyFailure, resp.Status)
+		return
+	}
+	if br.Buffered() > 0 {
+		conn = &bufferedConn{Conn: conn, r: br}
+	}
+	return
+}
+
+// bufferedConn reads the bytes buffered while parsing the proxy response first
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
@@ -0,0 +1,383 @@
+package socks5
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Errors of UserDB
+var (
+	ErrUserExists = errors.New("user exists")
+	ErrNoSuchUser = errors.New("no such user")
+)
+
+// User is an entry of UserDB
+type User struct {
+	Name       string
+	Hash       string // see VerifyPassword
+	Groups     []string
+	Disabled   bool
+	Attributes map[string]string
+
+	// TOTP is the base32 secret of the second factor, see UserDB
+	TOTP string
+}
+
+// UserDB authenticates the users of a file, one user per line.
+//
+//	# <username>:<hash> [groups=<group>,...] [disabled] [totp=<secret>] [<key>=<value>]...
+//	alice:$6$QpV1x3Ka$... groups=admin,staff totp=JBSWY3DPEHPK3PXP
+//	bob:$apr1$r31.....$HqJZimcKQFAMYayBlzkrA/ department=sales
+//	carol:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g= disabled
+//
+// Files of htpasswd are valid. The users are swapped at once on reload,
+// the established sessions are kept.
+//
+// The password of a user with a TOTP secret is followed by "+" and the
+// current code (RFC 6238), "password+123456". A code is accepted once.
+type UserDB struct {
+	Filename string
+
+	// TOTPSkew is the time steps accepted before and after the current one,
+	// LoadUserDB sets DefaultTOTPSkew
+	TOTPSkew int
+
+	// Logger logs the reload errors of Watch
+	Logger *log.Logger
+
+	mu      sync.RWMutex
+	users   map[string]*User
+	modTime time.Time
+	size    int64
+
+	// totpUsed is the last accepted time step of the users
+	totpUsed map[string]int64
+}
+
+// LoadUserDB loads the users of the file
+func LoadUserDB(filename string) (*UserDB, error) {
+	db := &UserDB{Filename: filename, TOTPSkew: DefaultTOTPSkew}
+	return db, db.Reload()
+}
+
+// Reload reads the file again, the old users are kept on error
+func (db *UserDB) Reload() error {
+	f, err := os.Open(db.Filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	users, err := ParseUsers(f)
+	if err != nil {
+		return fmt.Errorf("%s : %w", db.Filename, err)
+	}
+	db.mu.Lock()
+	db.users = users
+	db.modTime, db.size = st.ModTime(), st.Size()
+	db.mu.Unlock()
+	return nil
+}
+
+// Watch reloads the file whenever it changes until ctx is done
+func (db *UserDB) Watch(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			st, err := os.Stat(db.Filename)
+			if err != nil {
+				db.logf("%v", err)
+				continue
+			}
+			db.mu.RLock()
+			changed := !st.ModTime().Equal(db.modTime) || st.Size() != db.size
+			db.mu.RUnlock()
+			if !changed {
+				continue
+			}
+			if err := db.Reload(); err != nil {
+				db.logf("%v", err)
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (db *UserDB) logf(format string, v ...interface{}) {
+	if db.Logger != nil {
+		db.Logger.Printf(format, v...)
+	}
+}
+
+// ParseUsers parses the users in the format of UserDB
+func ParseUsers(r io.Reader) (map[string]*User, error) {
+	users := make(map[string]*User)
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		u, err := parseUser(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d : %w", n, err)
+		}
+		if _, ok := users[u.Name]; ok {
+			return nil, fmt.Errorf("line %d : %w : %s", n, ErrUserExists, u.Name)
+		}
+		users[u.Name] = u
+	}
+	return users, scanner.Err()
+}
+
+func parseUser(line string) (*User, error) {
+	fields := strings.Fields(line)
+	i := strings.IndexByte(fields[0], ':')
+	if i <= 0 {
+		return nil, fmt.Errorf("invalid user : %s", fields[0])
+	}
+	u := &User{Name: fields[0][:i], Hash: fields[0][i+1:]}
+	for _, f := range fields[1:] {
+		k, v := f, ""
+		if i := strings.IndexByte(f, '='); i >= 0 {
+			k, v = f[:i], f[i+1:]
+		}
+		switch k {
+		case "disabled":
+			u.Disabled = true
+		case "groups":
+			u.Groups = strings.Split(v, ",")
+		case "totp":
+			if _, err := decodeTOTPSecret(v); err != nil {
+				return nil, err
+			}
+			u.TOTP = v
+		default:
+			if v == "" {
+				return nil, fmt.Errorf("invalid attribute : %s", f)
+			}
+			if u.Attributes == nil {
+				u.Attributes = make(map[string]string)
+			}
+			u.Attributes[k] = v
+		}
+	}
+	return u, nil
+}
+
+func (u *User) String() string {
+	fields := []string{u.Name + ":" + u.Hash}
+	if len(u.Groups) > 0 {
+		fields = append(fields, "groups="+strings.Join(u.Groups, ","))
+	}
+	if u.Disabled {
+		fields = append(fields, "disabled")
+	}
+	if u.TOTP != "" {
+		fields = append(fields, "totp="+u.TOTP)
+	}
+	keys := make([]string, 0, len(u.Attributes))
+	for k := range u.Attributes {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fields = append(fields, k+"="+u.Attributes[k])
+	}
+	return strings.Join(fields, " ")
+}
+
+// User returns a copy of the user
+func (db *UserDB) User(name string) (User, bool) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	u, ok := db.users[name]
+	if !ok {
+		return User{}, false
+	}
+	return *u, true
+}
+
+// Users returns the sorted usernames
+func (db *UserDB) Users() []string {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.names()
+}
+
+func (db *UserDB) names() []string {
+	names := make([]string, 0, len(db.users))
+	for n := range db.users {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// dummyHash is verified for unknown users, so they take as long as known ones
+var dummyHash = sha512Crypt([]byte("socks5"), []byte("dummysalt"), sha512CryptRounds, false)
+
+// Authenticate is a Server.Authenticate, it checks the password of an
+// enabled user and sets the groups and attributes of Authentication.
+func (db *UserDB) Authenticate(ctx context.Context, auth *Authentication) bool {
+	if auth == nil {
+		return false
+	}
+	db.mu.RLock()
+	u, ok := db.users[string(auth.Username)]
+	db.mu.RUnlock()
+	if !ok {
+		VerifyPassword(dummyHash, string(auth.Password))
+		return false
+	}
+	password := string(auth.Password)
+	code, hasCode := "", false
+	if u.TOTP != "" {
+		password, code, hasCode = splitTOTP(password)
+	}
+	if !VerifyPassword(u.Hash, password) || u.Disabled {
+		return false
+	}
+	if u.TOTP != "" && (!hasCode || !db.useTOTP(u, code)) {
+		return false
+	}
+	attrs := make(map[string][]string, len(u.Attributes)+1)
+	if len(u.Groups) > 0 {
+		attrs[AttributeGroup] = u.Groups
+	}
+	for k, v := range u.Attributes {
+		attrs[k] = []string{v}
+	}
+	auth.Attributes = attrs
+	return true
+}
+
+// useTOTP verifies the code of the user, a time step is accepted once
+func (db *UserDB) useTOTP(u *User, code string) bool {
+	step, ok := verifyTOTP(u.TOTP, code, time.Now(), db.TOTPSkew)
+	if !ok {
+		return false
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if last, ok := db.totpUsed[u.Name]; ok && step <= last {
+		return false
+	}
+	if db.totpUsed == nil {
+		db.totpUsed = make(map[string]int64)
+	}
+	db.totpUsed[u.Name] = step
+	return true
+}
+
+// Add adds a user
+func (db *UserDB) Add(u *User) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if _, ok := db.users[u.Name]; ok {
+		return fmt.Errorf("%w : %s", ErrUserExists, u.Name)
+	}
+	if u.Name == "" || strings.ContainsAny(u.Name, ": \t#") {
+		return fmt.Errorf("invalid user : %q", u.Name)
+	}
+	if db.users == nil {
+		db.users = make(map[string]*User)
+	}
+	db.users[u.Name] = u
+	return nil
+}
+
+// Remove removes a user
+func (db *UserDB) Remove(name string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if _, ok := db.users[name]; !ok {
+		return fmt.Errorf("%w : %s", ErrNoSuchUser, name)
+	}
+	delete(db.users, name)
+	return nil
+}
+
+// SetPassword hashes the password of a user with the scheme
+func (db *UserDB) SetPassword(name, password, scheme string) error {
+	hash, err := HashPassword(password, scheme)
+	if err != nil {
+		return err
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	u, ok := db.users[name]
+	if !ok {
+		return fmt.Errorf("%w : %s", ErrNoSuchUser, name)
+	}
+	c := *u
+	c.Hash = hash
+	db.users[name] = &c
+	return nil
+}
+
+// SetTOTP sets the TOTP secret of a user, empty disables the second factor
+func (db *UserDB) SetTOTP(name, secret string) error {
+	if secret != "" {
+		if _, err := decodeTOTPSecret(secret); err != nil {
+			return err
+		}
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	u, ok := db.users[name]
+	if !ok {
+		return fmt.Errorf("%w : %s", ErrNoSuchUser, name)
+	}
+	c := *u
+	c.TOTP = secret
+	db.users[name] = &c
+	return nil
+}
+
+// Save writes the users to Filename atomically, comments are not kept
+func (db *UserDB) Save() error {
+	var b strings.Builder
+	db.mu.RLock()
+	for _, n := range db.names() {
+		b.WriteString(db.users[n].String())
+		b.WriteByte('\n')
+	}
+	db.mu.RUnlock()
+	f, err := ioutil.TempFile(filepath.Dir(db.Filename), ".users")
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(f, b.String()); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Chmod(0600); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), db.Filename)
+}
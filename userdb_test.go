@@ -0,0 +1,94 @@
+package socks5
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestUserDB(t *testing.T) {
+	dir, err := ioutil.TempDir("", "socks5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "users")
+	users := `# comment
+alice:$apr1$r31.....$HqJZimcKQFAMYayBlzkrA/ groups=admin,staff department=sales
+bob:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g= disabled
+`
+	if err := ioutil.WriteFile(file, []byte(users), 0600); err != nil {
+		t.Fatal(err)
+	}
+	db, err := LoadUserDB(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	auth := func(username, password string) *Authentication {
+		a := &Authentication{Username: []byte(username), Password: []byte(password)}
+		if !db.Authenticate(ctx, a) {
+			return nil
+		}
+		return a
+	}
+	a := auth("alice", "myPassword")
+	if a == nil || a.Attributes["department"][0] != "sales" ||
+		!MatchGroup("staff")(ctx, a, nil) || MatchGroup("dev")(ctx, a, nil) {
+		t.Fatal(a)
+	}
+	if auth("alice", "password") != nil || auth("bob", "password") != nil ||
+		auth("carol", "password") != nil {
+		t.Fatal("Error")
+	}
+
+	// edit and save
+	if err := db.Add(&User{Name: "carol", Groups: []string{"dev"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetPassword("carol", "secret", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Remove("bob"); err != nil {
+		t.Fatal(err)
+	}
+	if db.Add(&User{Name: "alice"}) == nil || db.Remove("bob") == nil ||
+		db.SetPassword("bob", "secret", "") == nil {
+		t.Fatal("Error")
+	}
+	if err := db.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// reload on change, a broken file keeps the users
+	db2, err := LoadUserDB(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go db2.Watch(ctx, 10*time.Millisecond)
+	if err := ioutil.WriteFile(file, []byte("broken\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if u, ok := db2.User("carol"); !ok || u.Groups[0] != "dev" || !VerifyPassword(u.Hash, "secret") {
+		t.Fatal(u)
+	}
+	if err := ioutil.WriteFile(file, []byte("dave:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; ; i++ {
+		if names := db2.Users(); len(names) == 1 && names[0] == "dave" {
+			break
+		}
+		if i == 100 {
+			t.Fatal(db2.Users())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}